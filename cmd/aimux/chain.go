@@ -0,0 +1,100 @@
+package main
+
+// chain.go - `aimux chain` pipes personas into each other in one process
+
+import (
+	"aimux/pkg/aimux"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// chainMain implements `aimux chain [options] P1,P2,... <prompt>`.
+func chainMain(args []string) {
+	fs := flag.NewFlagSet("chain", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aimux chain [options] P1,P2,... <prompt>")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Calls each persona in turn: the first with the prompt (and piped stdin),")
+		fmt.Fprintln(os.Stderr, "each later one with the previous answer. Stops at the first blocked or")
+		fmt.Fprintln(os.Stderr, "failed call.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	gen := fs.String("gen", "", "generator/genus/type (default $AIGEN or bash)")
+	cid := fs.String("cid", "", "conversation ID to resume (default $AICID)")
+	newConv := fs.Bool("new", false, "start a new conversation")
+	sys := fs.String("sys", "", "custom system prompt (overrides generation)")
+	last := fs.Bool("last", false, "print only the last answer")
+	rest := parseInterspersed(fs, args)
+
+	if len(rest) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	personas := splitList(rest[0])
+	cmdArgs := strings.TrimSpace(strings.Join(rest[1:], " "))
+	if len(personas) == 0 {
+		fmt.Fprintln(os.Stderr, "error: no personas to chain")
+		fs.Usage()
+		os.Exit(1)
+	}
+	stdin := pipedStdin()
+	if cmdArgs == "" && stdin == nil {
+		fmt.Fprintln(os.Stderr, "error: no prompt provided")
+		fs.Usage()
+		os.Exit(1)
+	}
+	genus := genusOrDefault(*gen)
+	if err := checkGenus(genus); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	conv, err := conversationID(*cid, *newConv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	join := func(hop int) (*aimux.Context, error) {
+		// Later hops are prompted with the previous answer, so the flow hints
+		// of the prompt only apply to the first
+		prompt := cmdArgs
+		if hop > 0 {
+			prompt = ""
+		}
+		return callContext(conv, genus, personas[hop], prompt, *sys)
+	}
+	output := func(hop int, c *aimux.Context) io.Writer {
+		if *last {
+			if hop < len(personas)-1 {
+				return io.Discard
+			}
+			return os.Stdout
+		}
+		if hop > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("===== %s (%d/%d) =====\n", aimux.SigTag(c), hop+1, len(personas))
+		return os.Stdout
+	}
+	results, err := aimux.Chain(context.Background(), len(personas), join, cmdArgs, stdin, output)
+	for _, r := range results {
+		if r.Record != nil {
+			fmt.Fprintf(os.Stderr, "%s / %s / %s\n", aimux.SigTag(r.Context), callTiming(r.Record), r.Context.CID)
+		}
+	}
+	if err != nil {
+		if len(results) == 0 || results[len(results)-1].Err == nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		r := results[len(results)-1]
+		fmt.Fprintf(os.Stderr, "%s (%d/%d): %s\n", aimux.SigTag(r.Context), len(results), len(personas), callError(r.Context, err))
+		os.Exit(aimux.ExitCode(err))
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+// fanout.go - `aimux fanout` asks several personas the same question at once
+
+import (
+	"aimux/pkg/aimux"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// fanoutMain implements `aimux fanout -mod=P1,P2,... [options] <prompt>`.
+func fanoutMain(args []string) {
+	fs := flag.NewFlagSet("fanout", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aimux fanout -mod=P1,P2,... [options] <prompt>")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Calls each persona with the same prompt (and piped stdin) concurrently,")
+		fmt.Fprintln(os.Stderr, "then prints their answers in -mod order, each under a header.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	mods := fs.String("mod", "", "comma-separated personas to call (required)")
+	gen := fs.String("gen", "", "generator/genus/type (default $AIGEN or bash)")
+	cid := fs.String("cid", "", "conversation ID to resume (default $AICID)")
+	newConv := fs.Bool("new", false, "start a new conversation")
+	parallel := fs.Int("parallel", 0, "maximum calls at once (0 for all)")
+	sys := fs.String("sys", "", "custom system prompt (overrides generation)")
+	cmdArgs := strings.TrimSpace(strings.Join(parseInterspersed(fs, args), " "))
+
+	personas := splitList(*mods)
+	if len(personas) == 0 {
+		fmt.Fprintln(os.Stderr, "error: -mod must name at least one persona")
+		fs.Usage()
+		os.Exit(1)
+	}
+	stdin := pipedStdin()
+	if cmdArgs == "" && stdin == nil {
+		fmt.Fprintln(os.Stderr, "error: no prompt provided")
+		fs.Usage()
+		os.Exit(1)
+	}
+	genus := genusOrDefault(*gen)
+	if err := checkGenus(genus); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	conv, err := conversationID(*cid, *newConv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	contexts := make([]*aimux.Context, len(personas))
+	for i, mod := range personas {
+		if contexts[i], err = callContext(conv, genus, mod, cmdArgs, *sys); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", mod, err)
+			os.Exit(1)
+		}
+	}
+
+	var results []aimux.CallResult
+	if client := aimux.DialDaemon(); client != nil {
+		results, err = client.Fanout(context.Background(), contexts, cmdArgs, stdin, *parallel)
+	} else {
+		results, err = aimux.Fanout(context.Background(), contexts, cmdArgs, stdin, *parallel)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fanout: %v\n", err)
+		os.Exit(1)
+	}
+
+	exit := 0
+	for i, r := range results {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("===== %s (%d/%d) =====\n", aimux.SigTag(r.Context), i+1, len(results))
+		os.Stdout.Write(r.Output)
+		if len(r.Output) > 0 && !bytes.HasSuffix(r.Output, []byte("\n")) {
+			fmt.Println()
+		}
+		if r.Err != nil {
+			fmt.Printf("[%s]\n", callError(r.Context, r.Err))
+			if exit == 0 {
+				exit = aimux.ExitCode(r.Err)
+			}
+		}
+		if r.Record != nil {
+			fmt.Fprintf(os.Stderr, "%s / %s / %s\n", aimux.SigTag(r.Context), callTiming(r.Record), r.Context.CID)
+		}
+	}
+	os.Exit(exit)
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// pipedStdin returns os.Stdin if it is piped (not a terminal), otherwise nil.
+func pipedStdin() io.Reader {
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice == 0 {
+		return os.Stdin
+	}
+	return nil
+}
+
+// genusOrDefault returns gen, else $AIGEN, else bash.
+func genusOrDefault(gen string) string {
+	if gen != "" {
+		return gen
+	}
+	if env := os.Getenv("AIGEN"); env != "" {
+		return env
+	}
+	return "bash"
+}
+
+// checkGenus returns an error naming the valid genera if gen is not one.
+func checkGenus(gen string) error {
+	cfg, err := aimux.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	if _, ok := cfg.GetGenus(gen); !ok {
+		return fmt.Errorf("invalid gen '%s' (valid: %s)", gen, strings.Join(validGeneraList(cfg), ", "))
+	}
+	return nil
+}
+
+// conversationID returns the conversation a multi-call subcommand works in:
+// cid (or $AICID), which must exist, or a new one with -new.
+func conversationID(cid string, newConv bool) (aimux.ID, error) {
+	if cid == "" && !newConv {
+		cid = os.Getenv("AICID")
+	}
+	if cid == "" {
+		if !newConv {
+			return "", errors.New("must specify -cid to resume or -new to create")
+		}
+		return aimux.NewID()
+	}
+	id := aimux.ID(aimux.NormalizeUUID(cid))
+	if _, err := aimux.ResumeContext(id, "bash", ""); err != nil {
+		return "", fmt.Errorf("conversation %s not found", id)
+	}
+	return id, nil
+}
+
+// callContext prepares the context of one call of a multi-call subcommand,
+// as main does for a single call: caller identity from the environment, flow
+// hints from the prompt and the custom system prompt, if any.
+func callContext(cid aimux.ID, gen, mod, cmdArgs, sys string) (*aimux.Context, error) {
+	ctx, err := aimux.JoinContext(cid, gen, mod)
+	if err != nil {
+		return nil, err
+	}
+	ctx.WTF = os.Getenv("AIWTF") != ""
+	if sys != "" {
+		ctx.ENV["AISYS"] = sys
+	}
+	for k, v := range aimux.InferFlowHints(cmdArgs) {
+		ctx.ENV["AI"+k] = v
+	}
+	return ctx, nil
+}
+
+// callError describes the error that ended a call: the blocking message for
+// protocol violations, the error itself otherwise.
+func callError(ctx *aimux.Context, err error) string {
+	var blockErr *aimux.BlockingError
+	if errors.As(err, &blockErr) {
+		return aimux.SysBlock(ctx, blockErr.Message)
+	}
+	return "error: " + err.Error()
+}
+
+// callTiming formats a call's elapsed time, with its usage if reported.
+func callTiming(rec *aimux.CallRecord) string {
+	timing := rec.End.Sub(rec.Start).Round(time.Millisecond).String()
+	if rec.Usage != nil {
+		timing += " / " + rec.Usage.String()
+	}
+	return timing
+}
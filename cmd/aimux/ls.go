@@ -0,0 +1,126 @@
+package main
+
+// ls.go - `aimux ls` lists conversations and their sessions
+
+import (
+	"aimux/pkg/aimux"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// lsMain implements `aimux ls [options]`.
+func lsMain(args []string) {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aimux ls [options]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	sortBy := fs.String("sort", "time", "sort by: time, cid, messages")
+	reverse := fs.Bool("r", false, "reverse sort order")
+	long := fs.Bool("l", false, "list each genus/persona session")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	fs.Parse(args)
+
+	var infos []aimux.ConversationInfo
+	var err error
+	if client := aimux.DialDaemon(); client != nil {
+		infos, err = client.List()
+	} else {
+		infos, err = aimux.ListConversations()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "list conversations: %v\n", err)
+		os.Exit(1)
+	}
+
+	var less func(a, b aimux.ConversationInfo) bool
+	switch *sortBy {
+	case "time":
+		// Most recent first
+		less = func(a, b aimux.ConversationInfo) bool { return a.LastActivity.After(b.LastActivity) }
+	case "cid":
+		less = func(a, b aimux.ConversationInfo) bool { return a.CID < b.CID }
+	case "messages":
+		less = func(a, b aimux.ConversationInfo) bool { return a.Messages > b.Messages }
+	default:
+		fmt.Fprintf(os.Stderr, "error: invalid sort '%s' (valid: time, cid, messages)\n", *sortBy)
+		os.Exit(1)
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		if *reverse {
+			return less(infos[j], infos[i])
+		}
+		return less(infos[i], infos[j])
+	})
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if infos == nil {
+			infos = []aimux.ConversationInfo{}
+		}
+		if err := enc.Encode(infos); err != nil {
+			fmt.Fprintf(os.Stderr, "encode: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CID\tLAST ACTIVITY\tMSGS\tGENERA\tPERSONAS\tSID\tFIRST PROMPT")
+	for _, info := range infos {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			info.CID,
+			formatTime(info.LastActivity),
+			info.Messages,
+			orDash(strings.Join(info.Genera, ",")),
+			orDash(strings.Join(info.Personas, ",")),
+			orDash(string(info.SID)),
+			oneLine(info.FirstPrompt, 60),
+		)
+		if *long {
+			for _, s := range info.Sessions {
+				fmt.Fprintf(tw, "  %s\t%s\t%d\t\t\t%s\t\n",
+					aimux.Tag3(&aimux.Context{GEN: s.GEN, MOD: s.MOD}),
+					formatTime(s.LastActivity),
+					s.Messages,
+					orDash(string(s.SID)),
+				)
+			}
+		}
+	}
+	tw.Flush()
+}
+
+// formatTime renders a timestamp in local time, or "-" if unset.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// orDash returns s, or "-" if s is empty (keeps table columns aligned).
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// oneLine collapses whitespace and truncates s to maxLen runes for table cells.
+func oneLine(s string, maxLen int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > maxLen {
+		return string(runes[:maxLen-3]) + "..."
+	}
+	return orDash(s)
+}
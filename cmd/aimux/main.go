@@ -6,6 +6,7 @@ import (
 	"aimux/pkg/aimux"
 	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -143,7 +144,8 @@ func validGeneraList(cfg *aimux.Config) []string {
 
 // handleError checks if error is a BlockingError and exits with appropriate code/message
 func handleError(ctx *aimux.Context, err error, prefix string) {
-	if blockErr, ok := err.(*aimux.BlockingError); ok {
+	var blockErr *aimux.BlockingError
+	if errors.As(err, &blockErr) {
 		fmt.Fprintf(os.Stderr, "%s\n", aimux.SysBlock(ctx, blockErr.Message))
 		os.Exit(blockErr.Code)
 	}
@@ -151,10 +153,86 @@ func handleError(ctx *aimux.Context, err error, prefix string) {
 	os.Exit(1)
 }
 
+// subcommand is the entry point of `aimux <name> ...`, and the check of the
+// positional argument it may start with (nil if it takes none first).
+type subcommand struct {
+	main func(args []string)
+	arg  func(arg string) bool
+}
+
+// subcommands maps `aimux <name> ...` to its subcommand. Any other first
+// argument is treated as part of the prompt.
+var subcommands = map[string]subcommand{
+	"cancel": {cancelMain, aimux.IsValidUUID},
+	"chain":  {chainMain, isPersonaList},
+	"fanout": {fanoutMain, nil},
+	"ls":     {lsMain, nil},
+	"recv":   {recvMain, aimux.IsValidUUID},
+	"send":   {sendMain, nil},
+	"serve":  {serveMain, nil},
+	"show":   {showMain, aimux.IsValidUUID},
+	"tree":   {treeMain, aimux.IsValidUUID},
+	"usage":  {usageMain, aimux.IsValidUUID},
+	"worker": {workerMain, nil},
+}
+
+// lookupSubcommand returns the subcommand args (os.Args[1:]) run. A
+// subcommand's name only runs it when followed by nothing, a flag or its
+// positional argument, so `aimux show me the diff` is still a prompt;
+// `aimux -- <prompt>` is always one.
+func lookupSubcommand(args []string) (subcommand, bool) {
+	if len(args) == 0 {
+		return subcommand{}, false
+	}
+	sub, ok := subcommands[args[0]]
+	switch {
+	case !ok:
+		return sub, false
+	case len(args) == 1, strings.HasPrefix(args[1], "-"):
+		return sub, true
+	}
+	return sub, sub.arg != nil && sub.arg(args[1])
+}
+
+// isPersonaList returns true if arg is a comma-separated list of personas,
+// or a single configured persona.
+func isPersonaList(arg string) bool {
+	if strings.Contains(arg, ",") {
+		return !strings.ContainsAny(arg, " \t\n")
+	}
+	cfg, err := aimux.LoadConfig()
+	if err != nil {
+		return false
+	}
+	_, ok := cfg.Personas[arg]
+	return ok
+}
+
 func main() {
+	// Dispatch subcommands before parsing prompt flags
+	if sub, ok := lookupSubcommand(os.Args[1:]); ok {
+		if os.Getenv("AIWTF") != "" {
+			aimux.SetLevel(aimux.DEBUG)
+		}
+		sub.main(os.Args[2:])
+		return
+	}
+
 	// Custom usage function with controlled flag order
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "usage: aimux [options] <prompt>")
+		fmt.Fprintln(os.Stderr, "       aimux [options] -- <prompt>   # prompt starting with a subcommand name")
+		fmt.Fprintln(os.Stderr, "       aimux chain [-gen=G] [-cid=UUID|-new] [-last] P1,P2,... <prompt>")
+		fmt.Fprintln(os.Stderr, "       aimux fanout -mod=P1,P2,... [-gen=G] [-cid=UUID|-new] [-parallel=N] <prompt>")
+		fmt.Fprintln(os.Stderr, "       aimux send [-gen=G] [-mod=P] [-cid=UUID|-new] <prompt>")
+		fmt.Fprintln(os.Stderr, "       aimux worker [-cid=UUID] [-once] [-poll=D]")
+		fmt.Fprintln(os.Stderr, "       aimux recv [-nowait] [-timeout=D] [-json] <ticket>")
+		fmt.Fprintln(os.Stderr, "       aimux serve [-sock=PATH]")
+		fmt.Fprintln(os.Stderr, "       aimux cancel <call>")
+		fmt.Fprintln(os.Stderr, "       aimux ls [-sort=time|cid|messages] [-r] [-l] [-json]")
+		fmt.Fprintln(os.Stderr, "       aimux show [-gen=G] [-mod=P] [-sid=UUID] [-format=markdown|plain|json] [-since=T] [-until=T] [cid]")
+		fmt.Fprintln(os.Stderr, "       aimux tree [-json] [cid]")
+		fmt.Fprintln(os.Stderr, "       aimux usage [-by=tag|persona|genus|conversation] [-since=T] [-json] [cid]")
 		fmt.Fprintln(os.Stderr, "")
 		fmt.Fprintln(os.Stderr, "Options:")
 		fmt.Fprintln(os.Stderr, "  -new             start new session (or branch current)")
@@ -365,8 +443,10 @@ func main() {
 			fmt.Fprintf(os.Stderr, "error: invalid rewind timestamp (use RFC3339 format): %v\n", err)
 			os.Exit(1)
 		}
-		ctx.ENV["AIRWD"] = cutoff.Format(time.RFC3339)
-		ctx.ENV["AITEMPORAL"] = "query"
+		if err := aimux.Rewind(ctx, cutoff); err != nil {
+			fmt.Fprintf(os.Stderr, "error: cannot rewind: %v\n", err)
+			os.Exit(1)
+		}
 		aimux.Debug("Temporal rewind to: %s", cutoff.Format(time.RFC3339))
 	}
 
@@ -381,53 +461,47 @@ func main() {
 		aimux.Debug("Model override from HUD: %s", modelOverride)
 	}
 
-	// Log user message (cmdArgs only - can't log streamed stdin without consuming it)
-	loggedPrompt := cmdArgs
-	if stdinReader != nil && cmdArgs != "" {
-		loggedPrompt = cmdArgs + " <<STDIN"
-	} else if stdinReader != nil {
-		loggedPrompt = "<<STDIN"
-	}
-
-	// Infer organic flow hints from prompt patterns
-	flowHints := aimux.InferFlowHints(loggedPrompt)
+	// Infer organic flow hints from prompt patterns (stdin is not read yet)
+	flowHints := aimux.InferFlowHints(cmdArgs)
 	for k, v := range flowHints {
 		ctx.ENV["AI"+k] = v
 		aimux.Debug("Flow hint: AI%s=%s", k, v)
 	}
 
-	if err := aimux.AppendMessage(ctx, "user", loggedPrompt); err != nil {
-		fmt.Fprintf(os.Stderr, "warning: failed to log user message: %v\n", err)
+	// Log the prompt, call genus CLI with streaming (pass cmdArgs and stdin
+	// reader separately), then record the call for `aimux tree`. A running
+	// daemon (`aimux serve`) makes the call instead, streaming its output back
+	var rec *aimux.CallRecord
+	if client := aimux.DialDaemon(); client != nil {
+		aimux.Debug("Calling through the daemon")
+		var id aimux.ID
+		if id, err = aimux.NewID(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		// The ID `aimux cancel` takes, while the call runs
+		fmt.Fprintf(os.Stderr, "%s / call %s\n", aimux.SigTag(ctx), id)
+		rec, err = client.Call(context.Background(), ctx, id, cmdArgs, stdinReader, os.Stdout)
+	} else {
+		rec, err = aimux.RunCall(context.Background(), ctx, cmdArgs, stdinReader, os.Stdout)
 	}
-
-	// Start timing
-	startTime := time.Now()
-
-	// Call genus CLI with streaming (pass cmdArgs and stdin reader separately)
-	stream, err := aimux.CallGenus(context.Background(), ctx, cmdArgs, stdinReader)
 	if err != nil {
-		handleError(ctx, err, "call genus")
-	}
-
-	// Stream and log the response
-	if err := aimux.StreamAndLog(ctx, stream, os.Stdout); err != nil {
-		stream.Close() // Clean up on error
-		handleError(ctx, err, "stream")
-	}
-
-	// Close and check for blocking errors from subprocess exit code
-	if err := stream.Close(); err != nil {
-		handleError(ctx, err, "subprocess")
+		handleError(ctx, err, "error")
 	}
 
 	// Calculate elapsed time
-	elapsed := time.Since(startTime).Round(time.Millisecond)
+	elapsed := rec.End.Sub(rec.Start).Round(time.Millisecond)
 
 	// Print session info to stderr
-	// Format: "Architect Claude / <elapsed> / <cid>" or with SID if different
+	// Format: "Architect Claude / <elapsed> / <cid>" or with SID if different,
+	// with "$<cost> / <n> tokens" after elapsed when the genus reported usage
+	timing := elapsed.String()
+	if rec.Usage != nil {
+		timing += " / " + rec.Usage.String()
+	}
 	if ctx.CID == ctx.SID {
-		fmt.Fprintf(os.Stderr, "\n\n%s / %s / %s\n", aimux.SigTag(ctx), elapsed, ctx.CID)
+		fmt.Fprintf(os.Stderr, "\n\n%s / %s / %s\n", aimux.SigTag(ctx), timing, ctx.CID)
 	} else {
-		fmt.Fprintf(os.Stderr, "\n\n%s / %s / %s (%s)\n", aimux.SigTag(ctx), elapsed, ctx.CID, ctx.SID)
+		fmt.Fprintf(os.Stderr, "\n\n%s / %s / %s (%s)\n", aimux.SigTag(ctx), timing, ctx.CID, ctx.SID)
 	}
 }
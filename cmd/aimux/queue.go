@@ -0,0 +1,189 @@
+package main
+
+// queue.go - `aimux send`, `aimux worker` and `aimux recv` for queued calls
+
+import (
+	"aimux/pkg/aimux"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// exitPending is the exit code of `aimux recv -nowait` for a ticket whose
+// call has not ended yet (EX_TEMPFAIL).
+const exitPending = 75
+
+// sendMain implements `aimux send [options] <prompt>`.
+func sendMain(args []string) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aimux send [options] <prompt>")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Queues the call for `aimux worker` and prints its ticket, to collect")
+		fmt.Fprintln(os.Stderr, "the answer later with `aimux recv <ticket>`.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	gen := fs.String("gen", "", "generator/genus/type (default $AIGEN or bash)")
+	mod := fs.String("mod", "", "model/persona/role (default $AIMOD)")
+	cid := fs.String("cid", "", "conversation ID to resume (default $AICID)")
+	newConv := fs.Bool("new", false, "start a new conversation")
+	sys := fs.String("sys", "", "custom system prompt (overrides generation)")
+	cmdArgs := strings.TrimSpace(strings.Join(parseInterspersed(fs, args), " "))
+
+	modFlagProvided := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "mod" {
+			modFlagProvided = true
+		}
+	})
+	if !modFlagProvided {
+		*mod = os.Getenv("AIMOD")
+	}
+	stdin := pipedStdin()
+	if cmdArgs == "" && stdin == nil {
+		fmt.Fprintln(os.Stderr, "error: no prompt provided")
+		fs.Usage()
+		os.Exit(1)
+	}
+	genus := genusOrDefault(*gen)
+	if err := checkGenus(genus); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	conv, err := conversationID(*cid, *newConv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	ctx, err := callContext(conv, genus, *mod, cmdArgs, *sys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	ticket, err := aimux.Send(ctx, cmdArgs, stdin)
+	if err != nil {
+		handleError(ctx, err, "send")
+	}
+	fmt.Println(ticket.ID)
+	fmt.Fprintf(os.Stderr, "%s / queued / %s\n", aimux.SigTag(ctx), ctx.CID)
+}
+
+// workerMain implements `aimux worker [options]`.
+func workerMain(args []string) {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aimux worker [-cid=UUID] [-once] [-poll=D]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Runs queued calls one at a time, oldest first. Several workers may run")
+		fmt.Fprintln(os.Stderr, "at once.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	cid := fs.String("cid", "", "only run calls queued in this conversation")
+	once := fs.Bool("once", false, "exit once the queue is empty")
+	poll := fs.Duration("poll", time.Second, "how often to check an empty queue")
+	fs.Parse(args)
+
+	conv := aimux.ID("")
+	if *cid != "" {
+		conv = aimux.ID(aimux.NormalizeUUID(*cid))
+	}
+	for {
+		ticket, err := aimux.ClaimTicket(conv)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "worker: %v\n", err)
+			os.Exit(1)
+		}
+		if ticket == nil {
+			if *once {
+				return
+			}
+			time.Sleep(*poll)
+			continue
+		}
+		if err := aimux.RunTicket(context.Background(), ticket); err != nil {
+			fmt.Fprintf(os.Stderr, "worker: ticket %s: %v\n", ticket.ID, err)
+			continue
+		}
+		elapsed := ticket.Finished.Sub(ticket.Started).Round(time.Millisecond)
+		fmt.Fprintf(os.Stderr, "%s / %s / %s / %s\n", ticket.Callee(), ticket.State, elapsed, ticket.ID)
+	}
+}
+
+// recvMain implements `aimux recv [options] <ticket>`.
+func recvMain(args []string) {
+	fs := flag.NewFlagSet("recv", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aimux recv [-nowait] [-timeout=D] [-json] <ticket>")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Waits for a queued call to end and prints its answer, exiting with the")
+		fmt.Fprintln(os.Stderr, "call's exit code.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	nowait := fs.Bool("nowait", false, fmt.Sprintf("do not wait; exit %d if the call has not ended", exitPending))
+	timeout := fs.Duration("timeout", 0, "give up waiting after this long (0 waits forever)")
+	poll := fs.Duration("poll", 500*time.Millisecond, "how often to check the ticket")
+	jsonOut := fs.Bool("json", false, "print the ticket as JSON")
+	rest := parseInterspersed(fs, args)
+	if len(rest) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	id := aimux.ID(aimux.NormalizeUUID(rest[0]))
+
+	var ticket *aimux.Ticket
+	var err error
+	if *nowait {
+		ticket, err = aimux.LoadTicket(id)
+	} else {
+		ctx := context.Background()
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		ticket, err = aimux.WaitTicket(ctx, id, *poll)
+		if err == context.DeadlineExceeded {
+			err = nil // Reported as pending below
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "recv: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *jsonOut {
+		ticket.Env = nil // The sender's environment, which may hold API keys
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(ticket); err != nil {
+			fmt.Fprintf(os.Stderr, "recv: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if !ticket.Done() {
+		fmt.Fprintf(os.Stderr, "%s / %s / %s\n", ticket.Callee(), ticket.State, ticket.ID)
+		os.Exit(exitPending)
+	}
+	if !*jsonOut {
+		os.Stdout.Write(ticket.Output)
+	}
+	if ticket.Error != "" {
+		msg := "error: " + ticket.Error
+		if ticket.Blocked {
+			msg = aimux.SysBlock(&aimux.Context{GEN: ticket.GEN, MOD: ticket.MOD}, ticket.Error)
+		}
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	os.Exit(ticket.Exit)
+}
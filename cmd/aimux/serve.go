@@ -0,0 +1,81 @@
+package main
+
+// serve.go - `aimux serve` runs the daemon; `aimux cancel` stops one of its calls
+
+import (
+	"aimux/pkg/aimux"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// serveMain implements `aimux serve [-sock=PATH]`.
+func serveMain(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aimux serve [-sock=PATH]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Runs the aimux daemon. While it runs, aimux calls, fanout, ls and show")
+		fmt.Fprintln(os.Stderr, "go through its socket ($AISOCK or ~/.aimux/aimux.sock).")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	sock := fs.String("sock", "", "socket path (default $AISOCK or ~/.aimux/aimux.sock)")
+	fs.Parse(args)
+
+	if *sock == "" {
+		path, err := aimux.SocketPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+			os.Exit(1)
+		}
+		*sock = path
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "aimux daemon listening on %s\n", *sock)
+	if err := aimux.Serve(ctx, *sock); err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// cancelMain implements `aimux cancel <call>`.
+func cancelMain(args []string) {
+	fs := flag.NewFlagSet("cancel", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aimux cancel <call>")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Cancels a call the daemon is running, by ID (AICALL within the call).")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if !aimux.IsValidUUID(fs.Arg(0)) {
+		fmt.Fprintf(os.Stderr, "error: invalid call '%s' (must be UUID format)\n", fs.Arg(0))
+		os.Exit(1)
+	}
+
+	client := aimux.DialDaemon()
+	if client == nil {
+		fmt.Fprintln(os.Stderr, "error: no aimux daemon running (see `aimux serve`)")
+		os.Exit(1)
+	}
+	id := aimux.ID(aimux.NormalizeUUID(fs.Arg(0)))
+	canceled, err := client.Cancel(id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cancel: %v\n", err)
+		os.Exit(1)
+	}
+	if !canceled {
+		fmt.Fprintf(os.Stderr, "error: call %s is not running\n", id)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+// show.go - `aimux show <cid>` renders a conversation transcript
+
+import (
+	"aimux/pkg/aimux"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// showMain implements `aimux show [options] [cid]`.
+func showMain(args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aimux show [options] [cid]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Renders the transcript of a conversation (default $AICID).")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	gen := fs.String("gen", "", "only this genus (glob)")
+	mod := fs.String("mod", "", "only this persona (glob; '-' for undifferentiated)")
+	sid := fs.String("sid", "", "only this session ID")
+	format := fs.String("format", aimux.FormatMarkdown, "output format: markdown, plain, json")
+	since := fs.String("since", "", "only messages at or after TIME (RFC3339, or duration ago like 2h)")
+	until := fs.String("until", "", "only messages at or before TIME (RFC3339, or duration ago like 2h)")
+	positional := parseInterspersed(fs, args)
+
+	cid := os.Getenv("AICID")
+	switch len(positional) {
+	case 0:
+	case 1:
+		cid = positional[0]
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+	if cid == "" {
+		fmt.Fprintln(os.Stderr, "error: no conversation ID (pass <cid> or set AICID)")
+		os.Exit(1)
+	}
+
+	filter := aimux.TranscriptFilter{
+		GEN: *gen,
+		MOD: *mod,
+		SID: aimux.ID(*sid),
+	}
+	var err error
+	if filter.Since, err = parseTimeArg(*since); err != nil {
+		fmt.Fprintf(os.Stderr, "error: invalid -since: %v\n", err)
+		os.Exit(1)
+	}
+	if filter.Until, err = parseTimeArg(*until); err != nil {
+		fmt.Fprintf(os.Stderr, "error: invalid -until: %v\n", err)
+		os.Exit(1)
+	}
+
+	var messages []aimux.TranscriptMessage
+	if client := aimux.DialDaemon(); client != nil {
+		messages, err = client.Show(aimux.ID(aimux.NormalizeUUID(cid)), filter)
+	} else {
+		messages, err = aimux.Transcript(aimux.ID(aimux.NormalizeUUID(cid)), filter)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "transcript: %v\n", err)
+		os.Exit(1)
+	}
+	if err := aimux.RenderTranscript(os.Stdout, messages, *format); err != nil {
+		fmt.Fprintf(os.Stderr, "render: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseInterspersed parses flags that may appear before or after positional
+// arguments (the flag package stops at the first positional) and returns the
+// positional arguments in order.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...)
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseTimeArg parses an RFC3339 timestamp or a duration meaning "that long
+// ago". Empty input returns the zero time.
+func parseTimeArg(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither RFC3339 nor a duration", s)
+	}
+	return time.Now().Add(-d), nil
+}
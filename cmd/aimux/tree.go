@@ -0,0 +1,106 @@
+package main
+
+// tree.go - `aimux tree <cid>` renders the delegation tree of a conversation
+
+import (
+	"aimux/pkg/aimux"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// treeMain implements `aimux tree [options] [cid]`.
+func treeMain(args []string) {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aimux tree [options] [cid]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Renders who delegated to whom in a conversation (default $AICID).")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	asJSON := fs.Bool("json", false, "print JSON instead of a tree")
+	positional := parseInterspersed(fs, args)
+
+	cid := os.Getenv("AICID")
+	switch len(positional) {
+	case 0:
+	case 1:
+		cid = positional[0]
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+	if cid == "" {
+		fmt.Fprintln(os.Stderr, "error: no conversation ID (pass <cid> or set AICID)")
+		os.Exit(1)
+	}
+
+	roots, err := aimux.CallTree(aimux.ID(aimux.NormalizeUUID(cid)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "call tree: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		if roots == nil {
+			roots = []*aimux.CallNode{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(roots); err != nil {
+			fmt.Fprintf(os.Stderr, "encode: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(roots) == 0 {
+		fmt.Fprintf(os.Stderr, "no calls recorded for %s\n", cid)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CALL\tSTART\tELAPSED\tEXIT\tSID")
+	for _, root := range roots {
+		writeCallNode(tw, root, "", "", true)
+	}
+	tw.Flush()
+}
+
+// writeCallNode writes one call and, indented beneath it, the calls it made.
+// Roots show "Caller → Callee"; children show only the callee since the
+// caller is the line above.
+func writeCallNode(w io.Writer, node *aimux.CallNode, prefix, branch string, root bool) {
+	label := node.Callee()
+	if root {
+		label = node.Caller() + " → " + label
+	}
+	fmt.Fprintf(w, "%s%s%s\t%s\t%s\t%d\t%s\n",
+		prefix, branch, label,
+		formatTime(node.Start),
+		node.End.Sub(node.Start).Round(time.Millisecond),
+		node.Exit,
+		orDash(string(node.SIDAfter)),
+	)
+
+	childPrefix := prefix
+	switch branch {
+	case "├── ":
+		childPrefix += "│   "
+	case "└── ":
+		childPrefix += "    "
+	}
+	for i, child := range node.Children {
+		next := "├── "
+		if i == len(node.Children)-1 {
+			next = "└── "
+		}
+		writeCallNode(w, child, childPrefix, next, false)
+	}
+}
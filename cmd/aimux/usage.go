@@ -0,0 +1,101 @@
+package main
+
+// usage.go - `aimux usage` reports token usage and cost
+
+import (
+	"aimux/pkg/aimux"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// usageMain implements `aimux usage [options] [cid]`.
+func usageMain(args []string) {
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: aimux usage [options] [cid]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Reports token usage and cost of recorded calls, across all")
+		fmt.Fprintln(os.Stderr, "conversations unless a CID is given.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	by := fs.String("by", aimux.UsageByPersona, "group by: tag, persona, genus, conversation")
+	since := fs.String("since", "", "only calls started at or after TIME (RFC3339, or duration ago like 24h)")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	positional := parseInterspersed(fs, args)
+
+	var cid aimux.ID
+	switch len(positional) {
+	case 0:
+	case 1:
+		cid = aimux.ID(aimux.NormalizeUUID(positional[0]))
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cutoff, err := parseTimeArg(*since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: invalid -since: %v\n", err)
+		os.Exit(1)
+	}
+
+	report, err := aimux.UsageReport(cid, *by, cutoff)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "usage report: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "encode: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var total aimux.UsageRow
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tCALLS\tINPUT\tCACHED\tOUTPUT\tCOST\n", headerFor(*by))
+	for _, row := range report {
+		writeUsageRow(tw, row)
+		total.Calls += row.Calls
+		total.Add(row.Usage)
+	}
+	if len(report) > 1 {
+		total.Key = "TOTAL"
+		writeUsageRow(tw, total)
+	}
+	tw.Flush()
+}
+
+// writeUsageRow writes one usage table row.
+func writeUsageRow(tw *tabwriter.Writer, row aimux.UsageRow) {
+	fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t$%.4f\n",
+		row.Key,
+		row.Calls,
+		aimux.FormatTokens(row.InputTokens+row.CacheCreationInputTokens),
+		aimux.FormatTokens(row.CacheReadInputTokens),
+		aimux.FormatTokens(row.OutputTokens),
+		row.CostUSD,
+	)
+}
+
+// headerFor returns the first column header for a usage grouping.
+func headerFor(by string) string {
+	switch by {
+	case aimux.UsageByConversation:
+		return "CID"
+	case aimux.UsageByTag:
+		return "TAG"
+	case aimux.UsageByGenus:
+		return "GENUS"
+	}
+	return "PERSONA"
+}
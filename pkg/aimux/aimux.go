@@ -18,6 +18,7 @@ const (
 	conversationsDir = "conversations"
 	logFileName      = "log.jsonl"
 	contextFileName  = "context.json"
+	callsFileName    = "calls.jsonl"
 
 	// Placeholder for empty persona in path construction
 	emptyModPlaceholder = "-"
@@ -38,6 +39,36 @@ type Context struct {
 	WTF bool              `json:"wtf"`
 	DIR string            `json:"dir,omitempty"`
 	ENV map[string]string `json:"env,omitempty"`
+
+	// Working directory and environment of the genus, for a call made on
+	// behalf of another process (see Serve and RunTicket); this process's if
+	// unset
+	workDir string
+	environ []string
+
+	// Budgets of the call being made, loaded once for all its attempts
+	budgets *callBudgets
+}
+
+// callEnviron returns the environment of c's genus.
+func callEnviron(c *Context) []string {
+	if c.environ != nil {
+		return append([]string(nil), c.environ...)
+	}
+	return os.Environ()
+}
+
+// callGetenv returns environment variable key as c's genus sees it.
+func callGetenv(c *Context, key string) string {
+	if c.environ == nil {
+		return os.Getenv(key)
+	}
+	for i := len(c.environ) - 1; i >= 0; i-- {
+		if k, v, ok := strings.Cut(c.environ[i], "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
 }
 
 // Message represents one interaction in JSONL log format.
@@ -117,13 +148,22 @@ func capitalize(s string) string {
 	return string(runes)
 }
 
-// Dir1 returns ~/.aimux/conversations/$CID/$GEN
-func Dir1(c *Context) (string, error) {
+// Dir0 returns ~/.aimux/conversations/$CID
+func Dir0(c *Context) (string, error) {
 	home, err := homeDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(home, aimuxDir, conversationsDir, string(c.CID), c.GEN), nil
+	return filepath.Join(home, aimuxDir, conversationsDir, string(c.CID)), nil
+}
+
+// Dir1 returns Dir0/$GEN
+func Dir1(c *Context) (string, error) {
+	dir0, err := Dir0(c)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir0, c.GEN), nil
 }
 
 // Dir2 returns Dir1 or Dir1/$MOD if MOD is set.
@@ -234,8 +274,10 @@ func SysStart(c *Context) string {
 	sb.WriteString(fmt.Sprintf("- Local callee is *%s* (you) connected to STDIO;\n", SigTag(c)))
 	sb.WriteString("- Leave **now** if caller and callee match to avoid calling yourself!\n")
 
-	// Add all AI env vars
-	for _, env := range Env(c) {
+	// Add all AI env vars, plus the depth budget and any remaining spend budget
+	vars := append(Env(c), fmt.Sprintf("AIMAXLVL=%d", maxDepth(c)), "AIBUDGET="+budgetRemaining(c))
+	sort.Strings(vars)
+	for _, env := range vars {
 		// Skip empty values (like the shell's /=$/d in sed)
 		if !strings.HasSuffix(env, "=") {
 			sb.WriteString(fmt.Sprintf("- %s\n", strings.Replace(env, "=", " is ", 1)))
@@ -244,6 +286,17 @@ func SysStart(c *Context) string {
 	return sb.String()
 }
 
+// maxDepth returns the configured call depth limit for the context's
+// genus and persona, falling back to the built-in default.
+func maxDepth(c *Context) int {
+	cfg, err := LoadConfig()
+	if err != nil {
+		Debug("LoadConfig failed in maxDepth, using default: %v", err)
+		return defaultMaxDepth
+	}
+	return cfg.DepthLimit(c.GEN, c.MOD)
+}
+
 // SysGuide generates standard protocol rules
 // (equivalent to ai::sys::guide in shell).
 func SysGuide(c *Context) string {
@@ -363,8 +416,16 @@ func SysReferencedContext(c *Context) string {
 		return ""
 	}
 
+	// Temporal rewind hides messages logged after the cutoff
+	var cutoff time.Time
+	if rwd := c.ENV["AIRWD"]; rwd != "" {
+		if t, err := time.Parse(time.RFC3339, rwd); err == nil {
+			cutoff = t
+		}
+	}
+
 	// Load recent messages from referenced conversation
-	messages, err := LoadReferencedContext(ID(refCID), 10) // Limit to 10 messages
+	messages, err := LoadReferencedContextBefore(ID(refCID), 10, cutoff) // Limit to 10 messages
 	if err != nil {
 		// Silently fail if conversation not found
 		return ""
@@ -382,7 +443,11 @@ func SysReferencedContext(c *Context) string {
 	for i, msg := range messages {
 		// Truncate long message bodies
 		body := truncate(msg.Body, 200)
-		sb.WriteString(fmt.Sprintf("  %d. [%s] %s\n", i+1, msg.From, body))
+		from := msg.From
+		if tag := messageTag(msg); tag != "" {
+			from = formatSig(tag) + ": " + from
+		}
+		sb.WriteString(fmt.Sprintf("  %d. [%s] %s\n", i+1, from, body))
 	}
 
 	sb.WriteString("\n")
@@ -422,57 +487,24 @@ func (e *BlockingError) Error() string {
 }
 
 // ValidateCall enforces partner protocol rules to prevent infinite recursion
-// and maintain persona boundaries. It implements four blocking checks:
+// and maintain persona boundaries. Rules come from the configured call policy
+// (see Config.CheckPolicy); the embedded default policy implements five checks:
 //
-// 1. Depth check (code 3): Blocks if recursion depth >= 3 levels
+// 1. Depth check (code 3): Blocks if recursion depth >= max_depth (default 3)
 // 2. Self-call check (code 1): Blocks if trying to call the exact same instance
 // 3. Engineer restriction (code 4): Blocks engineers from making any calls
 // 4. Undifferentiated→engineer check (code 5): Blocks this specific transition
+// 5. Delegatee check (code 6): Blocks calls to personas outside caller's delegatees
 //
 // Returns nil if the call is allowed, or a BlockingError with the appropriate
 // code and message if blocked.
 func ValidateCall(c *Context) error {
-	// Check 1: Depth exceeded (AILVL >= 3)
-	if c.LVL >= 3 {
-		return &BlockingError{
-			Code:    3,
-			Message: fmt.Sprintf("recursive call depth exceeded (%d)", c.LVL),
-		}
-	}
-
-	// Check 2: Self-call prevention (TAG == TOP)
-	// Prevents an agent from calling itself, which would create infinite recursion.
-	// This also blocks coordinator calls from differentiated personas when they
-	// attempt to spawn an undifferentiated instance.
-	if c.TAG != "" && c.TAG == c.TOP {
-		return &BlockingError{
-			Code:    1,
-			Message: fmt.Sprintf("you (%s) cannot call yourself", SigTag(c)),
-		}
-	}
-
-	// Check 3: Engineer restriction
-	// Engineers are leaf nodes in the call graph and cannot delegate further.
-	// Only enforced during partner protocol calls (TOP is set).
-	if c.TOP != "" && strings.Contains(c.TOP, "~") {
-		topParts := strings.Split(c.TOP, "~")
-		if topParts[0] == "engineer" {
-			return &BlockingError{
-				Code:    4,
-				Message: fmt.Sprintf("you (%s) cannot call anyone; ask your caller instead", SigTop(c)),
-			}
-		}
-	}
-
-	// Check 4: Undifferentiated→engineer restriction
-	// Prevents undifferentiated coordinators from calling engineers directly.
-	// They must go through an architect to maintain proper delegation hierarchy.
-	if c.TOP != "" && !strings.Contains(c.TOP, "~") && c.MOD == "engineer" {
-		return &BlockingError{
-			Code:    5,
-			Message: fmt.Sprintf("you (%s) cannot call %s; ask your team instead", SigTop(c), SigTag(c)),
+	cfg, err := LoadConfig()
+	if err != nil {
+		Debug("LoadConfig failed in ValidateCall, using defaults: %v", err)
+		if cfg, err = DefaultConfig(); err != nil {
+			return err
 		}
 	}
-
-	return nil
+	return cfg.CheckPolicy(c)
 }
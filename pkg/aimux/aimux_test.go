@@ -198,6 +198,12 @@ func TestPaths(t *testing.T) {
 
 // TestBlockingRules verifies all blocking rules work correctly
 func TestBlockingRules(t *testing.T) {
+	// Delegatee checks load config, so isolate from the real ~/.aimux
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
 	tests := []struct {
 		name      string
 		ctx       *Context
@@ -263,6 +269,52 @@ func TestBlockingRules(t *testing.T) {
 			},
 			wantError: false,
 		},
+		{
+			name: "customer cannot call engineer (not a delegatee)",
+			ctx: &Context{
+				LVL: 1,
+				TAG: "engineer~claude",
+				TOP: "customer~claude",
+				GEN: "claude",
+				MOD: "engineer",
+			},
+			wantError: true,
+			wantCode:  6,
+		},
+		{
+			name: "security can call engineer (delegatee)",
+			ctx: &Context{
+				LVL: 1,
+				TAG: "engineer~claude",
+				TOP: "security~claude",
+				GEN: "claude",
+				MOD: "engineer",
+			},
+			wantError: false,
+		},
+		{
+			name: "architect cannot call undifferentiated genus (not a delegatee)",
+			ctx: &Context{
+				LVL: 1,
+				TAG: "claude",
+				TOP: "architect~claude",
+				GEN: "claude",
+				MOD: "",
+			},
+			wantError: true,
+			wantCode:  6,
+		},
+		{
+			name: "unknown caller persona is unrestricted",
+			ctx: &Context{
+				LVL: 1,
+				TAG: "engineer~claude",
+				TOP: "haiku~claude",
+				GEN: "claude",
+				MOD: "engineer",
+			},
+			wantError: false,
+		},
 		{
 			name: "first call allowed (empty TOP)",
 			ctx: &Context{
@@ -437,6 +489,29 @@ func TestEnvironmentVariables(t *testing.T) {
 	}
 }
 
+// TestSysStartDepthBudget verifies the effective depth limit is advertised
+func TestSysStartDepthBudget(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	ctx := &Context{
+		CID: "12345678-1234-4123-8234-123456789abc",
+		SID: "12345678-1234-4123-8234-123456789abc",
+		GEN: "claude",
+		MOD: "architect",
+		LVL: 1,
+		ENV: map[string]string{},
+	}
+	ctx.TAG = Tag3(ctx)
+
+	got := SysStart(ctx)
+	if !strings.Contains(got, "- AIMAXLVL is 3\n") {
+		t.Errorf("SysStart() missing depth budget:\n%s", got)
+	}
+}
+
 // TestUUIDNormalization tests UUID normalization and validation
 func TestUUIDNormalization(t *testing.T) {
 	tests := []struct {
@@ -0,0 +1,101 @@
+package aimux
+
+// anthropic.go - Anthropic Messages API genus, without the claude CLI
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// anthropicVersion is the Messages API version requested.
+	anthropicVersion = "2023-06-01"
+
+	// anthropicMaxTokens caps replies unless the persona sets "max_tokens".
+	anthropicMaxTokens = 8192
+)
+
+// anthropicRequest builds a streaming POST to <url>/messages. The system
+// prompt travels outside the messages; max_tokens comes from the persona's
+// "max_tokens" var.
+func anthropicRequest(ctx context.Context, genus GenusConfig, req ChatRequest) (*http.Request, error) {
+	maxTokens := anthropicMaxTokens
+	if v := req.Vars["max_tokens"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid max_tokens %q", v)
+		}
+		maxTokens = n
+	}
+	httpReq, err := newJSONRequest(ctx, strings.TrimRight(genus.URL, "/")+"/messages", map[string]interface{}{
+		"model":      req.Model,
+		"max_tokens": maxTokens,
+		"system":     req.System,
+		"messages":   req.Messages,
+		"stream":     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+	if req.APIKey != "" {
+		httpReq.Header.Set("x-api-key", req.APIKey)
+	}
+	return httpReq, nil
+}
+
+// anthropicDecoder decodes Messages API stream events, outputting text deltas
+// as they arrive and logging the whole reply when the stream ends. Usage is
+// split across message_start (input) and message_delta (output).
+type anthropicDecoder struct {
+	chatReply
+	usage Usage
+}
+
+func (d *anthropicDecoder) Decode(line string) (StreamEvent, error) {
+	// "event:" lines repeat the type carried by the data
+	payload, ok := sseData(line)
+	if !ok {
+		return StreamEvent{}, nil
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		return StreamEvent{}, err
+	}
+
+	var ev StreamEvent
+	switch data["type"] {
+	case "error":
+		return d.fail(httpErrorMessage([]byte(payload))), nil
+	case "message_start":
+		message, _ := data["message"].(map[string]interface{})
+		usage, _ := message["usage"].(map[string]interface{})
+		d.usage = Usage{
+			InputTokens:              jsonInt(usage["input_tokens"]),
+			CacheCreationInputTokens: jsonInt(usage["cache_creation_input_tokens"]),
+			CacheReadInputTokens:     jsonInt(usage["cache_read_input_tokens"]),
+			OutputTokens:             jsonInt(usage["output_tokens"]),
+			Turns:                    1,
+		}
+	case "content_block_delta":
+		delta, _ := data["delta"].(map[string]interface{})
+		if delta["type"] == "text_delta" {
+			text, _ := delta["text"].(string)
+			d.text.WriteString(text)
+			ev.Text = text
+		}
+	case "message_delta":
+		// output_tokens is cumulative
+		if usage, ok := data["usage"].(map[string]interface{}); ok {
+			d.usage.OutputTokens = jsonInt(usage["output_tokens"])
+			u := d.usage
+			ev.Usage = &u
+		}
+	}
+	return ev, nil
+}
@@ -0,0 +1,141 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestAnthropicGenus verifies streaming, model fallback and history replay of
+// the Messages API genus against a mock server
+func TestAnthropicGenus(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	t.Setenv("AICALL", "")
+	t.Setenv("TEST_ANTHROPIC_KEY", "sk-ant-test")
+
+	server := &chatTestServer{}
+	server.reply = func(w http.ResponseWriter, body map[string]interface{}) {
+		event := func(typ, data string) {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ, data)
+		}
+		switch body["model"] {
+		case "overloaded-model":
+			w.WriteHeader(529)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+			return
+		case "bad-model":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","message":"bad request"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		event("message_start", `{"type":"message_start","message":{"id":"msg_1","role":"assistant","content":[],"usage":{"input_tokens":50,"cache_read_input_tokens":900,"output_tokens":1}}}`)
+		event("content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`)
+		event("content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"hmm"}}`)
+		event("content_block_delta", `{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hello from "}}`)
+		event("ping", `{"type":"ping"}`)
+		event("content_block_delta", fmt.Sprintf(`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":%q}}`, body["model"]))
+		if body["model"] == "flaky-model" {
+			event("error", `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+			return
+		}
+		event("message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":12}}`)
+		event("message_stop", `{"type":"message_stop"}`)
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	writeChatConfig(t, tmpDir, "anthropic", GenusConfig{
+		Name:      "anthropic",
+		Type:      GenusTypeAnthropic,
+		URL:       ts.URL + "/v1",
+		APIKeyEnv: "TEST_ANTHROPIC_KEY",
+		Format:    StreamFormatAnthropic,
+		Personas: map[string]PersonaVars{
+			"":          {"model": "overloaded-model", "model2": "claude-test", "max_tokens": "1024"},
+			"architect": {"model": "bad-model", "model2": "claude-test"},
+			"qa":        {"model": "flaky-model"},
+		},
+	})
+
+	ctx, err := InitContext("anthropic", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+
+	// The overloaded primary model falls back to model2 before any output
+	var out bytes.Buffer
+	rec, err := RunCall(context.Background(), ctx, "hi", nil, &out)
+	if err != nil {
+		t.Fatalf("RunCall() error = %v", err)
+	}
+	if got := out.String(); got != "Hello from claude-test\n" {
+		t.Errorf("RunCall() output = %q", got)
+	}
+	if len(server.requests) != 2 || server.requests[0]["model"] != "overloaded-model" || server.requests[1]["model"] != "claude-test" {
+		t.Fatalf("requests = %v, want overloaded-model then claude-test", server.requests)
+	}
+	req := server.requests[1]
+	if !strings.HasPrefix(fmt.Sprint(req["system"]), "PARTNER PROTOCOL") || req["max_tokens"] != float64(1024) || req["stream"] != true {
+		t.Errorf("request = %v", req)
+	}
+	if got := server.roles(t, 1); got != "user:hi" {
+		t.Errorf("request messages = %s", got)
+	}
+	h := server.headers[1]
+	if h.Get("x-api-key") != "sk-ant-test" || h.Get("anthropic-version") != "2023-06-01" || h.Get("Authorization") != "" {
+		t.Errorf("request headers = %v", h)
+	}
+	want := Usage{InputTokens: 50, CacheReadInputTokens: 900, OutputTokens: 12, Turns: 1}
+	if rec.Usage == nil || *rec.Usage != want {
+		t.Errorf("RunCall() usage = %+v, want %+v", rec.Usage, want)
+	}
+
+	// Later calls resume the session with its history
+	resumed, err := ResumeContext(ctx.CID, "anthropic", "")
+	if err != nil {
+		t.Fatalf("ResumeContext() error = %v", err)
+	}
+	if _, err := RunCall(context.Background(), resumed, "again", nil, &out); err != nil {
+		t.Fatalf("RunCall(resume) error = %v", err)
+	}
+	if got := server.roles(t, 3); got != "user:hi|assistant:Hello from claude-test|user:again" {
+		t.Errorf("resumed request messages = %s", got)
+	}
+
+	// Bad requests do not fall back
+	architect, err := ResumeContext(ctx.CID, "anthropic", "architect")
+	if err != nil {
+		t.Fatalf("ResumeContext() error = %v", err)
+	}
+	n := len(server.requests)
+	if _, err := RunCall(context.Background(), architect, "plan", nil, &out); err == nil || !strings.Contains(err.Error(), "bad request") {
+		t.Errorf("RunCall(bad model) error = %v", err)
+	}
+	if len(server.requests) != n+1 {
+		t.Errorf("bad request sent %d requests, want 1", len(server.requests)-n)
+	}
+
+	// Error events fail the call after partial output
+	qa, err := ResumeContext(ctx.CID, "anthropic", "qa")
+	if err != nil {
+		t.Fatalf("ResumeContext() error = %v", err)
+	}
+	out.Reset()
+	if _, err := RunCall(context.Background(), qa, "test", nil, &out); err == nil {
+		t.Errorf("RunCall(error event) succeeded")
+	}
+	if got := out.String(); got != "Hello from flaky-modelOverloaded\n" {
+		t.Errorf("RunCall(error event) output = %q", got)
+	}
+}
@@ -0,0 +1,281 @@
+package aimux
+
+// budget.go - Token, cost, call and wall-time budgets enforced before calling
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// budgetExhaustedCode is the BlockingError code for calls refused by a budget.
+const budgetExhaustedCode = 7
+
+// Budget limits the resources spent by the calls in a scope. Zero fields are
+// unlimited. MaxWallTime and Window are Go durations ("45m", "24h"): wall time
+// is the time the counted calls took in total, and a Window only counts calls
+// started within that long of now.
+type Budget struct {
+	MaxTokens   int64   `json:"max_tokens,omitempty"`
+	MaxCostUSD  float64 `json:"max_cost_usd,omitempty"`
+	MaxCalls    int     `json:"max_calls,omitempty"`
+	MaxWallTime string  `json:"max_wall_time,omitempty"`
+	Window      string  `json:"window,omitempty"`
+}
+
+// BudgetConfig holds the global budget (all conversations combined) and the
+// budget of each conversation. Persona budgets live in PersonaConfig.
+type BudgetConfig struct {
+	Global       *Budget `json:"global,omitempty"`
+	Conversation *Budget `json:"conversation,omitempty"`
+}
+
+// budgetSpend is what the calls counted against a budget have used.
+type budgetSpend struct {
+	Tokens  int64
+	CostUSD float64
+	Calls   int
+	Elapsed time.Duration
+}
+
+// budgetScope is a budget with what has been spent against it.
+type budgetScope struct {
+	Name   string
+	Budget Budget
+	Spend  budgetSpend
+}
+
+// callBudgets holds the budget scopes loaded for one call (see
+// loadCallBudgets).
+type callBudgets struct {
+	scopes []budgetScope
+}
+
+// loadCallBudgets loads the budget scopes of the call to c about to be made,
+// for its attempts and system prompts to share until clearCallBudgets: the
+// spend cannot change before the call is recorded.
+func loadCallBudgets(c *Context) error {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	scopes, err := cfg.loadBudgetScopes(c)
+	if err != nil {
+		return fmt.Errorf("check budget: %w", err)
+	}
+	c.budgets = &callBudgets{scopes: scopes}
+	return nil
+}
+
+// clearCallBudgets drops the budget scopes loadCallBudgets loaded for c.
+func clearCallBudgets(c *Context) {
+	c.budgets = nil
+}
+
+// budgetScopes returns the budgets that apply to a call to c: those loaded
+// for the call by loadCallBudgets, or else freshly loaded ones.
+func (cfg *Config) budgetScopes(c *Context) ([]budgetScope, error) {
+	if c.budgets != nil {
+		return c.budgets.scopes, nil
+	}
+	return cfg.loadBudgetScopes(c)
+}
+
+// loadBudgetScopes returns the budgets that apply to a call to c, with their
+// spend: global, then the conversation, then calls to c's persona within the
+// conversation. Scopes without a budget are omitted.
+func (cfg *Config) loadBudgetScopes(c *Context) ([]budgetScope, error) {
+	var scopes []budgetScope
+
+	if b := cfg.Budgets.Global; b != nil {
+		cids, err := conversationIDs()
+		if err != nil {
+			return nil, err
+		}
+		var records []CallRecord
+		for _, cid := range cids {
+			recs, err := LoadCalls(cid)
+			if err != nil {
+				Warn("Skipping calls of %s in global budget: %v", cid, err)
+				continue
+			}
+			records = append(records, recs...)
+		}
+		scopes = append(scopes, budgetScope{Name: "global", Budget: *b, Spend: spendOf(records, *b, nil)})
+	}
+
+	var persona *Budget
+	if p, ok := cfg.Personas[c.MOD]; ok && c.MOD != "" {
+		persona = p.Budget
+	}
+	if cfg.Budgets.Conversation == nil && persona == nil {
+		return scopes, nil
+	}
+
+	records, err := LoadCalls(c.CID)
+	if err != nil {
+		return nil, err
+	}
+	if b := cfg.Budgets.Conversation; b != nil {
+		scopes = append(scopes, budgetScope{Name: "conversation", Budget: *b, Spend: spendOf(records, *b, nil)})
+	}
+	if persona != nil {
+		ofPersona := func(r CallRecord) bool {
+			mod, _ := splitTag(r.Tag)
+			return mod == c.MOD
+		}
+		scopes = append(scopes, budgetScope{Name: "persona " + c.MOD, Budget: *persona, Spend: spendOf(records, *persona, ofPersona)})
+	}
+	return scopes, nil
+}
+
+// spendOf totals the usage of records within b's window that match filter
+// (all if nil). Blocked calls used nothing and are not counted.
+func spendOf(records []CallRecord, b Budget, filter func(CallRecord) bool) budgetSpend {
+	var since time.Time
+	if window := parseBudgetDuration("window", b.Window); window > 0 {
+		since = time.Now().Add(-window)
+	}
+
+	var spend budgetSpend
+	for _, rec := range records {
+		if rec.Blocked || rec.Start.Before(since) || (filter != nil && !filter(rec)) {
+			continue
+		}
+		spend.Calls++
+		spend.Elapsed += time.Duration(rec.ElapsedMS) * time.Millisecond
+		if rec.Usage != nil {
+			spend.Tokens += rec.Usage.TotalTokens()
+			spend.CostUSD += rec.Usage.CostUSD
+		}
+	}
+	return spend
+}
+
+// parseBudgetDuration parses a budget duration, warning about (and
+// ignoring) invalid values.
+func parseBudgetDuration(field, s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		Warn("Ignoring invalid budget %s %q", field, s)
+		return 0
+	}
+	return d
+}
+
+// exhausted describes the first limit of the scope that has been reached,
+// or returns empty string if there is budget left.
+func (s budgetScope) exhausted() string {
+	b, spend := s.Budget, s.Spend
+	switch {
+	case b.MaxCalls > 0 && spend.Calls >= b.MaxCalls:
+		return fmt.Sprintf("max_calls %d reached", b.MaxCalls)
+	case b.MaxCostUSD > 0 && spend.CostUSD >= b.MaxCostUSD:
+		return fmt.Sprintf("max_cost_usd $%.2f reached ($%.4f spent)", b.MaxCostUSD, spend.CostUSD)
+	case b.MaxTokens > 0 && spend.Tokens >= b.MaxTokens:
+		return fmt.Sprintf("max_tokens %s reached (%s used)", FormatTokens(b.MaxTokens), FormatTokens(spend.Tokens))
+	}
+	if wall := parseBudgetDuration("max_wall_time", b.MaxWallTime); wall > 0 && spend.Elapsed >= wall {
+		return fmt.Sprintf("max_wall_time %s reached (%s used)", wall, spend.Elapsed.Round(time.Second))
+	}
+	return ""
+}
+
+// CheckBudget refuses a call to c with a BlockingError (code 7) if any budget
+// that applies to it (see budgetScopes) is exhausted.
+func (cfg *Config) CheckBudget(c *Context) error {
+	scopes, err := cfg.budgetScopes(c)
+	if err != nil {
+		return fmt.Errorf("check budget: %w", err)
+	}
+	for _, scope := range scopes {
+		if reason := scope.exhausted(); reason != "" {
+			return &BlockingError{
+				Code:    budgetExhaustedCode,
+				Message: fmt.Sprintf("%s budget exhausted for %s: %s", scope.Name, SigTag(c), reason),
+			}
+		}
+	}
+	return nil
+}
+
+// BudgetRemaining summarizes the tightest remaining budget across the scopes
+// that apply to a call to c, e.g. "12 calls, $3.2000, 150.0k tokens, 40m0s".
+// Returns empty string if no budget applies.
+func (cfg *Config) BudgetRemaining(c *Context) string {
+	scopes, err := cfg.budgetScopes(c)
+	if err != nil {
+		Debug("Budget unavailable: %v", err)
+		return ""
+	}
+
+	calls, tokens, cost, wall := -1, int64(-1), -1.0, time.Duration(-1)
+	for _, s := range scopes {
+		b, spend := s.Budget, s.Spend
+		if b.MaxCalls > 0 {
+			if left := nonNegative(int64(b.MaxCalls - spend.Calls)); calls < 0 || int(left) < calls {
+				calls = int(left)
+			}
+		}
+		if b.MaxTokens > 0 {
+			if left := nonNegative(b.MaxTokens - spend.Tokens); tokens < 0 || left < tokens {
+				tokens = left
+			}
+		}
+		if b.MaxCostUSD > 0 {
+			left := b.MaxCostUSD - spend.CostUSD
+			if left < 0 {
+				left = 0
+			}
+			if cost < 0 || left < cost {
+				cost = left
+			}
+		}
+		if max := parseBudgetDuration("max_wall_time", b.MaxWallTime); max > 0 {
+			left := max - spend.Elapsed
+			if left < 0 {
+				left = 0
+			}
+			if wall < 0 || left < wall {
+				wall = left.Round(time.Second)
+			}
+		}
+	}
+
+	var parts []string
+	if calls >= 0 {
+		parts = append(parts, fmt.Sprintf("%d calls", calls))
+	}
+	if cost >= 0 {
+		parts = append(parts, fmt.Sprintf("$%.4f", cost))
+	}
+	if tokens >= 0 {
+		parts = append(parts, FormatTokens(tokens)+" tokens")
+	}
+	if wall >= 0 {
+		parts = append(parts, wall.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// nonNegative clamps n at zero.
+func nonNegative(n int64) int64 {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// budgetRemaining loads config and returns BudgetRemaining for c, or empty
+// string if config cannot be loaded.
+func budgetRemaining(c *Context) string {
+	cfg, err := LoadConfig()
+	if err != nil {
+		Debug("LoadConfig failed in budgetRemaining: %v", err)
+		return ""
+	}
+	return cfg.BudgetRemaining(c)
+}
@@ -0,0 +1,146 @@
+package aimux
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCheckBudget verifies budgets at each scope block calls once exhausted
+func TestCheckBudget(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	cid1 := ID("12345678-1234-4123-8234-123456789abc")
+	cid2 := ID("87654321-1234-4123-8234-123456789abc")
+	now := time.Now().UTC()
+	root := filepath.Join(tmpDir, ".aimux", "conversations")
+
+	writeTestLog(t, filepath.Join(root, string(cid1), callsFileName),
+		CallRecord{ID: "a", CID: cid1, Tag: "architect~claude", Start: now.Add(-2 * time.Hour), ElapsedMS: 45 * 60 * 1000, Usage: &Usage{InputTokens: 900, OutputTokens: 100, CostUSD: 0.5}},
+		CallRecord{ID: "b", CID: cid1, Tag: "engineer~claude", Start: now.Add(-time.Minute), ElapsedMS: 20 * 60 * 1000, Usage: &Usage{InputTokens: 1500, OutputTokens: 500, CostUSD: 0.25}},
+		CallRecord{ID: "c", CID: cid1, Tag: "qa~claude", Start: now, Blocked: true, Exit: 6},
+	)
+	writeTestLog(t, filepath.Join(root, string(cid2), callsFileName),
+		CallRecord{ID: "d", CID: cid2, Tag: "architect~claude", Start: now, Usage: &Usage{InputTokens: 1000, CostUSD: 1}},
+	)
+
+	tests := []struct {
+		name     string
+		mod      string
+		budgets  BudgetConfig
+		persona  *Budget
+		wantErr  string
+		wantLeft string
+	}{
+		{"no budgets", "architect", BudgetConfig{}, nil, "", ""},
+		{"conversation calls left", "architect", BudgetConfig{Conversation: &Budget{MaxCalls: 3}}, nil, "", "1 calls"},
+		{"conversation calls exhausted", "architect", BudgetConfig{Conversation: &Budget{MaxCalls: 2}}, nil, "conversation budget exhausted for Architect Claude: max_calls 2 reached", "0 calls"},
+		{"conversation cost exhausted", "architect", BudgetConfig{Conversation: &Budget{MaxCostUSD: 0.75}}, nil, "max_cost_usd $0.75 reached", "$0.0000"},
+		{"global cost", "architect", BudgetConfig{Global: &Budget{MaxCostUSD: 2}}, nil, "", "$0.2500"},
+		{"global window", "architect", BudgetConfig{Global: &Budget{MaxCostUSD: 1, Window: "1h"}}, nil, "global budget exhausted", "$0.0000"},
+		{"wall time", "architect", BudgetConfig{Conversation: &Budget{MaxWallTime: "1h"}}, nil, "max_wall_time 1h0m0s reached (1h5m0s used)", "0s"},
+		{"wall time left", "architect", BudgetConfig{Conversation: &Budget{MaxWallTime: "90m"}}, nil, "", "25m0s"},
+		{"persona tokens left", "architect", BudgetConfig{}, &Budget{MaxTokens: 1500}, "", "500 tokens"},
+		{"persona tokens exhausted", "architect", BudgetConfig{}, &Budget{MaxTokens: 1000}, "persona architect budget exhausted", "0 tokens"},
+		{"tightest across scopes", "architect", BudgetConfig{Conversation: &Budget{MaxTokens: 10000, MaxCalls: 5}}, &Budget{MaxTokens: 1500}, "", "3 calls, 500 tokens"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := DefaultConfig()
+			if err != nil {
+				t.Fatalf("DefaultConfig() error = %v", err)
+			}
+			cfg.Budgets = tt.budgets
+			p := cfg.Personas[tt.mod]
+			p.Budget = tt.persona
+			cfg.Personas[tt.mod] = p
+
+			c := &Context{CID: cid1, SID: cid1, GEN: "claude", MOD: tt.mod}
+			err = cfg.CheckBudget(c)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckBudget() error = %v, want nil", err)
+				}
+			} else {
+				var blockErr *BlockingError
+				if !errors.As(err, &blockErr) || blockErr.Code != budgetExhaustedCode {
+					t.Fatalf("CheckBudget() error = %v, want BlockingError code %d", err, budgetExhaustedCode)
+				}
+				if !strings.Contains(blockErr.Message, tt.wantErr) {
+					t.Errorf("CheckBudget() message = %q, want %q", blockErr.Message, tt.wantErr)
+				}
+			}
+			if got := cfg.BudgetRemaining(c); got != tt.wantLeft {
+				t.Errorf("BudgetRemaining() = %q, want %q", got, tt.wantLeft)
+			}
+		})
+	}
+}
+
+// TestCallBudgets verifies a call's budgets are loaded once for all its attempts
+func TestCallBudgets(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	cid := ID("12345678-1234-4123-8234-123456789abc")
+	writeTestLog(t, filepath.Join(tmpDir, ".aimux", "config.json"), `{"budgets": {"global": {"max_calls": 1}}}`)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	c := &Context{CID: cid, SID: cid, GEN: "claude", MOD: "architect"}
+	if err := loadCallBudgets(c); err != nil {
+		t.Fatalf("loadCallBudgets() error = %v", err)
+	}
+	// A call recorded meanwhile elsewhere is not reread until the next call
+	writeTestLog(t, filepath.Join(tmpDir, ".aimux", "conversations", string(cid), callsFileName),
+		CallRecord{ID: "a", CID: cid, Tag: "architect~claude", Start: time.Now().UTC()},
+	)
+	if err := cfg.CheckBudget(c); err != nil {
+		t.Errorf("CheckBudget() error = %v, want the budget loaded for the call", err)
+	}
+	if got := cfg.BudgetRemaining(c); got != "1 calls" {
+		t.Errorf("BudgetRemaining() = %q, want %q", got, "1 calls")
+	}
+	clearCallBudgets(c)
+	if err := cfg.CheckBudget(c); err == nil {
+		t.Error("CheckBudget() after the call succeeded, want exhausted")
+	}
+}
+
+// TestSysStartBudget verifies the remaining budget is advertised when configured
+func TestSysStartBudget(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	ctx := &Context{
+		CID: "12345678-1234-4123-8234-123456789abc",
+		SID: "12345678-1234-4123-8234-123456789abc",
+		GEN: "claude",
+		MOD: "architect",
+		LVL: 1,
+		ENV: map[string]string{},
+	}
+	ctx.TAG = Tag3(ctx)
+
+	if got := SysStart(ctx); strings.Contains(got, "AIBUDGET") {
+		t.Errorf("SysStart() advertises budget without one configured:\n%s", got)
+	}
+
+	writeTestLog(t, filepath.Join(tmpDir, ".aimux", "config.json"), `{"budgets": {"conversation": {"max_calls": 10, "max_cost_usd": 5}}}`)
+	if got := SysStart(ctx); !strings.Contains(got, "- AIBUDGET is 10 calls, $5.0000\n") {
+		t.Errorf("SysStart() missing remaining budget:\n%s", got)
+	}
+}
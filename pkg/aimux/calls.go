@@ -0,0 +1,460 @@
+package aimux
+
+// calls.go - Call execution and the per-conversation call record
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// callEnvVar carries the current call's ID to child processes so nested
+	// calls can record their parent.
+	callEnvVar = "AICALL"
+
+	// callEnvKey stores the current call's ID in Context.ENV.
+	callEnvKey = "_AIMUX_CALL"
+
+	// fallbackEnvKey stores the model2 a retried call falls back to in
+	// Context.ENV (see runGenus).
+	fallbackEnvKey = "_AIMUX_FALLBACK"
+
+	// callTagPrefix marks the Message.Tags entry naming the call that logged
+	// a prompt (or a reply, for genera whose replies aimux logs itself)
+	callTagPrefix = "call:"
+)
+
+// CallRecord is one partner call, as recorded in Dir0/calls.jsonl by the
+// process that made it. Parent is the ID of the call that spawned this one
+// (empty for calls made directly by the main user). Exit is the BlockingError
+// code when Blocked, otherwise the genus exit status (1 for other failures).
+type CallRecord struct {
+	ID          ID        `json:"id"`
+	Parent      ID        `json:"parent,omitempty"`
+	CID         ID        `json:"cid"`
+	SIDBefore   ID        `json:"sid_before"`
+	SIDAfter    ID        `json:"sid_after"`
+	Top         string    `json:"top"`
+	Tag         string    `json:"tag"`
+	LVL         int       `json:"lvl"`
+	Argv        []string  `json:"argv,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	ElapsedMS   int64     `json:"elapsed_ms"`
+	Exit        int       `json:"exit"`
+	Blocked     bool      `json:"blocked,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	OutputBytes int64     `json:"output_bytes"`
+	Truncated   bool      `json:"truncated,omitempty"`
+	Usage       *Usage    `json:"usage,omitempty"`
+	Fallback    *Fallback `json:"fallback,omitempty"`
+	Attempts    int       `json:"attempts,omitempty"` // Set when the call was retried
+}
+
+// Fallback records a call answered by the persona's model2 because its model
+// failed before answering.
+type Fallback struct {
+	From  string `json:"from"`  // The model that failed
+	To    string `json:"to"`    // The model2 that answered
+	Error string `json:"error"` // Why From failed
+}
+
+// Caller returns the caller's signature (e.g. "Main User").
+func (r CallRecord) Caller() string {
+	return SigTop(&Context{TOP: r.Top})
+}
+
+// Callee returns the callee's signature (e.g. "Architect Claude").
+func (r CallRecord) Callee() string {
+	return formatSig(r.Tag)
+}
+
+// CallNode is a call and the calls it delegated to.
+type CallNode struct {
+	CallRecord
+	Children []*CallNode `json:"children,omitempty"`
+}
+
+// RunCall performs one partner call: it logs the user prompt, invokes the
+// genus via CallGenus, streams and logs its output to w via StreamAndLog, then
+// appends a CallRecord to the conversation's calls.jsonl. The record is returned
+// whenever the call was attempted, including when it was blocked or failed;
+// err is the error that ended the call.
+func RunCall(ctx context.Context, c *Context, cmdArgs string, stdin io.Reader, w io.Writer) (*CallRecord, error) {
+	id, err := NewID()
+	if err != nil {
+		return nil, err
+	}
+	return runCall(ctx, c, id, ID(os.Getenv(callEnvVar)), cmdArgs, stdin, w)
+}
+
+// runCall is RunCall with the call's ID and parent given, for calls made on
+// behalf of another process (see RunTicket and Serve). The call can be
+// canceled by ID with CancelCall while it runs.
+func runCall(ctx context.Context, c *Context, id, parent ID, cmdArgs string, stdin io.Reader, w io.Writer) (*CallRecord, error) {
+	ctx, done := trackCall(ctx, id)
+	defer done()
+	if c.ENV == nil {
+		c.ENV = make(map[string]string)
+	}
+	c.ENV[callEnvKey] = string(id)
+	defer delete(c.ENV, callEnvKey)
+
+	rec := &CallRecord{
+		ID:        id,
+		Parent:    parent,
+		CID:       c.CID,
+		SIDBefore: c.SID,
+		Top:       c.TOP,
+		Tag:       Tag3(c),
+		LVL:       c.LVL,
+		Start:     time.Now().UTC(),
+	}
+
+	// Log the prompt up front: its log entry also marks this persona's log as
+	// existing for buildSessionFlags. Stdin is recorded as the genus reads it
+	// and the entry is completed once the call ends.
+	stdin, finishPrompt := logPrompt(c, id, cmdArgs, stdin)
+	err := runGenus(ctx, c, cmdArgs, stdin, w, rec)
+	finishPrompt()
+
+	rec.End = time.Now().UTC()
+	rec.ElapsedMS = rec.End.Sub(rec.Start).Milliseconds()
+	rec.SIDAfter = c.SID
+	rec.Exit = ExitCode(err)
+	if err != nil {
+		var blockErr *BlockingError
+		rec.Blocked = errors.As(err, &blockErr)
+		rec.Error = err.Error()
+	}
+	if werr := appendCallRecord(c, rec); werr != nil {
+		Warn("Failed to record call %s: %v", rec.ID, werr)
+	}
+	return rec, err
+}
+
+// runGenus calls the genus, streams its output, and waits for it to exit,
+// filling in the argv, output, usage, fallback and attempts fields of rec. A
+// genus without native fallback (see fallbackModel) that fails before
+// answering is retried at once with the persona's model2, and transient
+// failures are retried with backoff per the genus's RetryPolicy. Failures
+// after output are never retried. Retries replay stdin; the prompt stays
+// logged once. BlockingErrors are returned unwrapped; other errors are
+// prefixed with the stage that failed.
+func runGenus(ctx context.Context, c *Context, cmdArgs string, stdin io.Reader, w io.Writer, rec *CallRecord) error {
+	from, to, err := fallbackModel(c)
+	if err != nil {
+		return stageError("call genus", err)
+	}
+	retry, err := newRetrier(c)
+	if err != nil {
+		return stageError("call genus", err)
+	}
+	if err := loadCallBudgets(c); err != nil {
+		return stageError("call genus", err)
+	}
+	defer clearCallBudgets(c)
+	var replay *replayReader
+	if (to != "" || retry.canRetry(1)) && stdin != nil {
+		replay = &replayReader{r: stdin}
+		stdin = replay
+	}
+	defer delete(c.ENV, fallbackEnvKey)
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			rec.Attempts = attempt
+		}
+		canFallback := to != "" && rec.Fallback == nil
+		early, err := attemptGenus(ctx, c, cmdArgs, stdin, w, rec, canFallback || retry.canRetry(attempt))
+		// The stream may have fallen back by itself (HTTPStream)
+		canFallback = canFallback && rec.Fallback == nil
+
+		switch {
+		case early != "" && canFallback:
+			Warn("Model %s failed before answering (%s), falling back to %s", from, early, to)
+			rec.Fallback = &Fallback{From: from, To: to, Error: early}
+			c.ENV[fallbackEnvKey] = to
+		case early != "" && retry.canRetry(attempt) && retry.retryable(nil, early, 0):
+			Warn("Attempt %d failed before answering (%s), retrying", attempt, early)
+			if err := retry.wait(ctx, attempt); err != nil {
+				return stageError("retry", err)
+			}
+		case early != "":
+			// Held back for a fallback or retry that cannot happen: report it
+			n, _ := fmt.Fprintln(w, early)
+			rec.OutputBytes += int64(n)
+			return stageError("stream", errGenusFailed)
+		case err != nil && rec.OutputBytes == 0 && retry.canRetry(attempt) && retry.retryable(err, err.Error(), ExitCode(err)):
+			Warn("Attempt %d failed (%v), retrying", attempt, err)
+			if err := retry.wait(ctx, attempt); err != nil {
+				return stageError("retry", err)
+			}
+		default:
+			return err
+		}
+
+		if err := resetAttempt(c, rec); err != nil {
+			return stageError("retry", err)
+		}
+		if replay != nil {
+			stdin = replay.replay()
+		}
+	}
+}
+
+// resetAttempt undoes the session changes of a failed attempt: the SID it
+// started from is restored, unless the attempt started a new session with an
+// ID we chose, which the genus may have claimed; the retry gets a fresh one.
+func resetAttempt(c *Context, rec *CallRecord) error {
+	c.SID = rec.SIDBefore
+	if c.ENV["_AIMUX_NEW_SESSION"] != "true" {
+		return nil
+	}
+	sid, err := NewID()
+	if err != nil {
+		return err
+	}
+	Debug("Retrying new session %s as %s", c.SID, sid)
+	c.SID = sid
+	return nil
+}
+
+// attemptGenus makes one attempt at a call (see runGenus). With
+// holdEarlyFailure, an error reported before any output ends the attempt
+// without being output, and is returned as early instead.
+func attemptGenus(ctx context.Context, c *Context, cmdArgs string, stdin io.Reader, w io.Writer, rec *CallRecord, holdEarlyFailure bool) (early string, err error) {
+	stream, err := CallGenus(ctx, c, cmdArgs, stdin)
+	if err != nil {
+		return "", stageError("call genus", err)
+	}
+	if s, ok := stream.(interface{ Argv() []string }); ok {
+		rec.Argv = s.Argv()
+	}
+	// Streams that fall back by themselves (HTTPStream) report it
+	defer func() {
+		if s, ok := stream.(interface{ Fallback() *Fallback }); ok && s.Fallback() != nil {
+			rec.Fallback = s.Fallback()
+		}
+	}()
+
+	result, err := streamAndLog(c, stream, w, holdEarlyFailure)
+	rec.OutputBytes = result.OutputBytes
+	rec.Truncated = result.Truncated
+	rec.Usage = result.Usage
+	if result.EarlyFailure != "" {
+		stream.Close() // Stop the failed attempt
+		return result.EarlyFailure, nil
+	}
+	if err != nil {
+		stream.Close() // Clean up on error
+		return "", stageError("stream", err)
+	}
+	if err := stream.Close(); err != nil {
+		return "", stageError("subprocess", err)
+	}
+	// HTTP genera report errors in the stream, with nothing to exit nonzero
+	if result.Failed {
+		return "", stageError("stream", errGenusFailed)
+	}
+	return "", nil
+}
+
+// replayReader records what is read through it, so that a retried attempt
+// can read the same input again.
+type replayReader struct {
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func (rr *replayReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.buf.Write(p[:n])
+	return n, err
+}
+
+// replay returns a reader of everything read so far followed by the rest of
+// the input, which is still recorded.
+func (rr *replayReader) replay() io.Reader {
+	return io.MultiReader(bytes.NewReader(rr.buf.Bytes()), rr)
+}
+
+// errGenusFailed fails a call whose genus reported an error in its output
+// but exited cleanly.
+var errGenusFailed = errors.New("genus reported an error")
+
+// errEarlyFailure stops a stream at a held early failure (see streamAndLog).
+var errEarlyFailure = errors.New("genus failed before answering")
+
+// runningCalls maps the ID of each call running in this process to the
+// function canceling it.
+var runningCalls = struct {
+	sync.Mutex
+	cancel map[ID]context.CancelFunc
+}{cancel: make(map[ID]context.CancelFunc)}
+
+// trackCall registers call id as running until done is called, returning the
+// context CancelCall cancels.
+func trackCall(ctx context.Context, id ID) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	runningCalls.Lock()
+	runningCalls.cancel[id] = cancel
+	runningCalls.Unlock()
+	return ctx, func() {
+		runningCalls.Lock()
+		delete(runningCalls.cancel, id)
+		runningCalls.Unlock()
+		cancel()
+	}
+}
+
+// CancelCall cancels call id if it is running in this process (e.g. in a
+// daemon, see Serve), killing its genus. It returns false if no such call
+// is running.
+func CancelCall(id ID) bool {
+	runningCalls.Lock()
+	cancel, ok := runningCalls.cancel[id]
+	runningCalls.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// stageError prefixes err with stage unless it is a BlockingError.
+func stageError(stage string, err error) error {
+	var blockErr *BlockingError
+	if errors.As(err, &blockErr) {
+		return err
+	}
+	return fmt.Errorf("%s: %w", stage, err)
+}
+
+// ExitCode maps a call error to a process exit code: 0 for success, the
+// BlockingError code for protocol violations, the subprocess exit status for
+// failed genus processes, and 1 otherwise.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var blockErr *BlockingError
+	if errors.As(err, &blockErr) {
+		return blockErr.Code
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	var remoteErr *remoteError
+	if errors.As(err, &remoteErr) && remoteErr.Exit > 0 {
+		return remoteErr.Exit
+	}
+	return 1
+}
+
+// appendCallRecord appends rec to Dir0/calls.jsonl. Nothing is written if
+// the conversation directory does not exist yet (e.g. the first call of a new
+// conversation was blocked), to avoid creating empty conversations.
+func appendCallRecord(c *Context, rec *CallRecord) error {
+	dir0, err := Dir0(c)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir0); os.IsNotExist(err) {
+		Debug("Not recording call %s: %s does not exist", rec.ID, dir0)
+		return nil
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal call record: %w", err)
+	}
+	if err := appendLocked(filepath.Join(dir0, callsFileName), append(data, '\n')); err != nil {
+		return fmt.Errorf("write calls log: %w", err)
+	}
+	return nil
+}
+
+// LoadCalls reads the call records of a conversation in the order they were
+// written (calls are recorded when they end, so children precede parents).
+// Returns nil if the conversation has no recorded calls.
+func LoadCalls(cid ID) ([]CallRecord, error) {
+	dir0, err := Dir0(&Context{CID: cid})
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(dir0, callsFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open calls log: %w", err)
+	}
+	defer f.Close()
+
+	var records []CallRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineLength)
+	for scanner.Scan() {
+		var rec CallRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			Warn("Skipping malformed call record: %v", err)
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read calls log: %w", err)
+	}
+	return records, nil
+}
+
+// CallTree builds the delegation tree of a conversation from its call
+// records. Calls whose parent was not recorded in this conversation (direct
+// user calls, or calls spawned from another conversation) are roots. Roots and
+// children are ordered by start time.
+func CallTree(cid ID) ([]*CallNode, error) {
+	records, err := LoadCalls(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[ID]*CallNode, len(records))
+	for i := range records {
+		nodes[records[i].ID] = &CallNode{CallRecord: records[i]}
+	}
+
+	var roots []*CallNode
+	for i := range records {
+		node := nodes[records[i].ID]
+		if parent, ok := nodes[node.Parent]; ok && node.Parent != node.ID {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	sortCallNodes(roots)
+	return roots, nil
+}
+
+// sortCallNodes orders nodes and their descendants by start time.
+func sortCallNodes(nodes []*CallNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Start.Before(nodes[j].Start)
+	})
+	for _, node := range nodes {
+		sortCallNodes(node.Children)
+	}
+}
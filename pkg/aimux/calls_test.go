@@ -0,0 +1,351 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCallRecordsCalls(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	t.Setenv("AICALL", "")
+
+	ctx, err := InitContext("bash", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+
+	// The call ID is exported to the genus as AICALL
+	var out bytes.Buffer
+	rec, err := RunCall(context.Background(), ctx, "echo $AICALL", nil, &out)
+	if err != nil {
+		t.Fatalf("RunCall() error = %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != string(rec.ID) {
+		t.Errorf("genus saw AICALL=%q, want %q", got, rec.ID)
+	}
+
+	// Calls made without RunCall, on this context or a resumed one, have no ID
+	for _, c := range []*Context{ctx, mustResume(t, ctx.CID)} {
+		stream, err := CallGenus(context.Background(), c, "echo \"[$AICALL]\"", nil)
+		if err != nil {
+			t.Fatalf("CallGenus() error = %v", err)
+		}
+		data, _ := io.ReadAll(stream)
+		stream.Close()
+		if got := strings.TrimSpace(string(data)); got != "[]" {
+			t.Errorf("CallGenus() genus saw AICALL %s, want none", got)
+		}
+	}
+	if rec.Exit != 0 || rec.Blocked || rec.Tag != "bash" || rec.Top != "" || rec.Parent != "" {
+		t.Errorf("RunCall() record = %+v", rec)
+	}
+	if rec.End.Before(rec.Start) || rec.ElapsedMS != rec.End.Sub(rec.Start).Milliseconds() {
+		t.Errorf("RunCall() start %v, end %v, elapsed %dms", rec.Start, rec.End, rec.ElapsedMS)
+	}
+	if rec.OutputBytes != int64(out.Len()) || rec.Truncated {
+		t.Errorf("RunCall() output = %d bytes (truncated %v), want %d", rec.OutputBytes, rec.Truncated, out.Len())
+	}
+	if len(rec.Argv) == 0 || rec.Argv[0] != "bash" {
+		t.Errorf("RunCall() argv = %q", rec.Argv)
+	}
+	if rec.SIDBefore != ctx.CID || rec.SIDAfter != ctx.SID {
+		t.Errorf("RunCall() SID %s -> %s, want %s -> %s", rec.SIDBefore, rec.SIDAfter, ctx.CID, ctx.SID)
+	}
+
+	// Genus failures record the exit status
+	rec, err = RunCall(context.Background(), ctx, "exit 7", nil, &out)
+	if err == nil || rec.Exit != 7 || rec.Blocked {
+		t.Errorf("RunCall(exit 7): err = %v, record = %+v", err, rec)
+	}
+
+	// Blocked calls are recorded with their blocking code
+	ctx.LVL = 3
+	rec, err = RunCall(context.Background(), ctx, "echo blocked", nil, &out)
+	if ExitCode(err) != 3 || rec.Exit != 3 || !rec.Blocked {
+		t.Errorf("RunCall() at depth limit: err = %v, record = %+v, want blocked with code 3", err, rec)
+	}
+
+	records, err := LoadCalls(ctx.CID)
+	if err != nil {
+		t.Fatalf("LoadCalls() error = %v", err)
+	}
+	if len(records) != 3 || records[0].Exit != 0 || records[1].Exit != 7 || records[2].Exit != 3 {
+		t.Errorf("LoadCalls() = %+v, want a successful, a failed and a blocked call", records)
+	}
+}
+
+func TestRunCallBlockedFirstCall(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	ctx, err := InitContext("bash", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+	ctx.LVL = 3
+
+	if _, err := RunCall(context.Background(), ctx, "echo blocked", nil, &bytes.Buffer{}); err == nil {
+		t.Fatal("RunCall() expected blocking error")
+	}
+
+	// The prompt is logged before the call, so even a blocked first call is recorded
+	messages, err := Transcript(ctx.CID, TranscriptFilter{})
+	if err != nil || len(messages) != 1 || messages[0].Body != "echo blocked" {
+		t.Errorf("Transcript() = %+v, %v, want the blocked prompt", messages, err)
+	}
+	records, err := LoadCalls(ctx.CID)
+	if err != nil || len(records) != 1 || !records[0].Blocked {
+		t.Errorf("LoadCalls() = %+v, %v, want one blocked call", records, err)
+	}
+}
+
+func TestRunCallCapturesStdin(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+
+	writeTestLog(t, filepath.Join(tmpDir, ".aimux", "config.json"), `{"stdin_cap": 16}`)
+
+	ctx, err := InitContext("bash", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+
+	// Small stdin is logged inline, ahead of the response
+	if _, err := RunCall(context.Background(), ctx, "cat", strings.NewReader("short input\n"), &bytes.Buffer{}); err != nil {
+		t.Fatalf("RunCall() error = %v", err)
+	}
+	messages, err := Transcript(ctx.CID, TranscriptFilter{})
+	if err != nil {
+		t.Fatalf("Transcript() error = %v", err)
+	}
+	if len(messages) != 2 || messages[0].Body != "cat\n\nshort input\n" || messages[1].Body != "short input" {
+		t.Fatalf("Transcript() = %+v, want complete prompt then response", messages)
+	}
+
+	// Large stdin spills to a sidecar file
+	log3, err := Log3(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(log3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("0123456789", 10)
+	rec, err := RunCall(context.Background(), ctx, "wc -c", strings.NewReader(large), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("RunCall() error = %v", err)
+	}
+
+	// The complete prompt is appended, not rewritten into the log
+	after, err := os.ReadFile(log3)
+	if err != nil || !bytes.HasPrefix(after, before) || bytes.Count(after, []byte(callTagPrefix+rec.ID)) != 2 {
+		t.Errorf("log = %s, %v, want the call's entries appended", after, err)
+	}
+	if sid, err := lastSessionID(log3); err != nil || sid != ctx.SID {
+		t.Errorf("lastSessionID() = %v, %v, want %v", sid, err, ctx.SID)
+	}
+	messages, err = Transcript(ctx.CID, TranscriptFilter{})
+	if err != nil || len(messages) != 4 {
+		t.Fatalf("Transcript() = %+v, %v", messages, err)
+	}
+	prompt := messages[2]
+	if !strings.HasPrefix(prompt.Body, "wc -c\n\n"+large[:16]+"\n[stdin truncated: 100 bytes total") {
+		t.Errorf("large prompt body = %q", prompt.Body)
+	}
+	sidecar := filepath.Join(tmpDir, ".aimux", "conversations", string(ctx.CID), stdinDir, string(rec.ID)+".txt")
+	if data, err := os.ReadFile(sidecar); err != nil || string(data) != large {
+		t.Errorf("sidecar = %q, %v, want full input", data, err)
+	}
+	var tagged bool
+	for _, tag := range prompt.Tags {
+		tagged = tagged || tag == stdinTagPrefix+sidecar
+	}
+	if !tagged {
+		t.Errorf("large prompt tags = %q, want sidecar tag", prompt.Tags)
+	}
+}
+
+// fakeModels emulates a CLI genus speaking codex JSONL events: it records its
+// argv and stdin, and fails before answering when run with --model flaky.
+const fakeModels = `#!/bin/bash
+dir=$(dirname "$0")
+echo "$@" >> "$dir/argv"
+cat >> "$dir/stdin"
+if [[ "$2" == flaky ]]; then
+  echo '{"type":"turn.failed","error":{"message":"rate limited"}}'
+  exit 1
+fi
+echo '{"type":"item.completed","item":{"type":"agent_message","text":"answered by '"$2"'"}}'
+`
+
+func TestRunCallFallback(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	t.Setenv("AICALL", "")
+
+	script := filepath.Join(tmpDir, "bin", "models")
+	writeTestLog(t, script, fakeModels)
+	if err := os.Chmod(script, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	genus := GenusConfig{
+		Name:   "models",
+		Exe:    []string{script},
+		Format: StreamFormatCodex,
+		Args:   GenusArgs{Model: []string{"--model", "{{model}}"}},
+		Personas: map[string]PersonaVars{
+			"":          {"model": "flaky", "model2": "steady"},
+			"architect": {"model": "flaky", "model2": "flaky"},
+		},
+	}
+	writeChatConfig(t, tmpDir, "models", genus)
+
+	ctx, err := InitContext("models", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+
+	// The failure before any answer is hidden and model2 answers the same input
+	var out bytes.Buffer
+	rec, err := RunCall(context.Background(), ctx, "", strings.NewReader("question"), &out)
+	if err != nil {
+		t.Fatalf("RunCall() error = %v", err)
+	}
+	if got := out.String(); got != "answered by steady\n" {
+		t.Errorf("RunCall() output = %q", got)
+	}
+	want := Fallback{From: "flaky", To: "steady", Error: "rate limited"}
+	if rec.Fallback == nil || *rec.Fallback != want {
+		t.Errorf("RunCall() fallback = %+v, want %+v", rec.Fallback, want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tmpDir, "bin", "stdin")); string(data) != "questionquestion" {
+		t.Errorf("genus stdin = %q, want the input replayed", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tmpDir, "bin", "argv")); string(data) != "--model flaky\n--model steady\n" {
+		t.Errorf("genus argv = %q", data)
+	}
+	if records, err := LoadCalls(ctx.CID); err != nil || len(records) != 1 || records[0].Fallback == nil {
+		t.Errorf("LoadCalls() = %+v, %v, want the fallback recorded", records, err)
+	}
+	// The prompt is logged once
+	messages, err := Transcript(ctx.CID, TranscriptFilter{})
+	if err != nil || len(messages) != 2 || messages[0].Body != "question" {
+		t.Errorf("Transcript() = %+v, %v, want one prompt and the answer", messages, err)
+	}
+
+	// The next call (a new process) tries model again, not model2
+	next, err := ResumeContext(ctx.CID, "models", "")
+	if err != nil {
+		t.Fatalf("ResumeContext() error = %v", err)
+	}
+	if _, ok := next.ENV[fallbackEnvKey]; ok {
+		t.Errorf("context.json saved %s", fallbackEnvKey)
+	}
+	out.Reset()
+	if rec, err = RunCall(context.Background(), next, "", strings.NewReader("again"), &out); err != nil || rec.Fallback == nil {
+		t.Errorf("second RunCall() = %v, fallback %+v, want it to fall back again", err, rec.Fallback)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tmpDir, "bin", "argv")); string(data) != "--model flaky\n--model steady\n--model flaky\n--model steady\n" {
+		t.Errorf("genus argv after second call = %q", data)
+	}
+
+	// Without a distinct model2 the failure is reported as is
+	architect, err := ResumeContext(ctx.CID, "models", "architect")
+	if err != nil {
+		t.Fatalf("ResumeContext() error = %v", err)
+	}
+	out.Reset()
+	rec, err = RunCall(context.Background(), architect, "", strings.NewReader("plan"), &out)
+	if err == nil || rec.Fallback != nil || out.String() != "rate limited\n" {
+		t.Errorf("RunCall(no model2) = %v, fallback %+v, output %q", err, rec.Fallback, out.String())
+	}
+}
+
+func TestCallTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	cid := ID("12345678-1234-4123-8234-123456789abc")
+	base := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+	path := filepath.Join(tmpDir, ".aimux", "conversations", string(cid), callsFileName)
+
+	// Records are written as calls end, so children come first
+	writeTestLog(t, path,
+		CallRecord{ID: "c2", Parent: "c1", CID: cid, Top: "architect~claude", Tag: "engineer~claude", LVL: 1, Start: base.Add(2 * time.Second)},
+		CallRecord{ID: "c3", Parent: "c1", CID: cid, Top: "architect~claude", Tag: "bash", LVL: 1, Start: base.Add(1 * time.Second)},
+		`not json`,
+		CallRecord{ID: "c1", CID: cid, Tag: "architect~claude", Start: base},
+		CallRecord{ID: "c4", Parent: "elsewhere", CID: cid, Tag: "codex", Start: base.Add(time.Hour)},
+	)
+
+	roots, err := CallTree(cid)
+	if err != nil {
+		t.Fatalf("CallTree() error = %v", err)
+	}
+	if len(roots) != 2 || roots[0].ID != "c1" || roots[1].ID != "c4" {
+		t.Fatalf("CallTree() roots = %+v, want c1 and c4", roots)
+	}
+	if got := roots[0].Caller() + " → " + roots[0].Callee(); got != "Main User → Architect Claude" {
+		t.Errorf("root label = %q", got)
+	}
+	children := roots[0].Children
+	if len(children) != 2 || children[0].ID != "c3" || children[1].Callee() != "Engineer Claude" {
+		t.Errorf("CallTree() children = %+v, want c3 then c2", children)
+	}
+
+	// No calls recorded
+	roots, err = CallTree("00000000-0000-4000-8000-000000000000")
+	if err != nil || roots != nil {
+		t.Errorf("CallTree(missing) = %v, %v, want nil, nil", roots, err)
+	}
+}
+
+func TestTrimPartialRune(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", ""},
+		{"abc", "abc"},
+		{"abé", "abé"},
+		{"ab\xc3", "ab"},
+		{"ab\xe2\x82", "ab"},
+		{"€\xe2\x82", "€"},
+	}
+	for _, tt := range tests {
+		if got := trimPartialRune(tt.in); got != tt.want {
+			t.Errorf("trimPartialRune(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// mustResume returns the bash context of conversation cid.
+func mustResume(t *testing.T, cid ID) *Context {
+	t.Helper()
+	c, err := ResumeContext(cid, "bash", "")
+	if err != nil {
+		t.Fatalf("ResumeContext() error = %v", err)
+	}
+	return c
+}
@@ -0,0 +1,46 @@
+package aimux
+
+// chain.go - Calling personas in turn, each answering the previous one
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// Chain makes n calls in turn, a pipeline of personas: the first gets
+// cmdArgs and stdin, and each later one gets the previous answer as its
+// prompt, under a provenance header (see chainPrompt). join returns the
+// context of a hop just before it runs, so a persona appearing twice resumes
+// the session its first hop left. Each hop is validated, logged and recorded
+// like a direct call by the chain's caller, and its output streamed to
+// w(hop, c) as well. The chain stops at the first hop that fails or is
+// blocked, returning the results so far with that hop's error.
+func Chain(ctx context.Context, n int, join func(hop int) (*Context, error), cmdArgs string, stdin io.Reader, w func(hop int, c *Context) io.Writer) ([]CallResult, error) {
+	var results []CallResult
+	prompt := cmdArgs
+	for hop := 0; hop < n; hop++ {
+		c, err := join(hop)
+		if err != nil {
+			return results, fmt.Errorf("hop %d: %w", hop+1, err)
+		}
+		var out bytes.Buffer
+		rec, err := RunCall(ctx, c, prompt, stdin, io.MultiWriter(&out, w(hop, c)))
+		results = append(results, CallResult{Context: c, Record: rec, Output: out.Bytes(), Err: err})
+		if err != nil {
+			return results, err
+		}
+		prompt = chainPrompt(results[hop], hop, n)
+		stdin = nil
+	}
+	return results, nil
+}
+
+// chainPrompt returns the prompt passing hop's answer to the next hop: a
+// header naming who answered and the call that answered, then the answer.
+// The header is a Markdown heading, which a shell genus skips as a comment.
+func chainPrompt(r CallResult, hop, n int) string {
+	return fmt.Sprintf("# From %s (chain %d/%d, call %s)\n\n%s",
+		SigTag(r.Context), hop+1, n, r.Record.ID, bytes.TrimSpace(r.Output))
+}
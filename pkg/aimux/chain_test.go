@@ -0,0 +1,83 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	t.Setenv("AICALL", "")
+
+	cid, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := func(mods []string, cmdArgs string, stdin io.Reader) ([]CallResult, string, error) {
+		var streamed bytes.Buffer
+		join := func(hop int) (*Context, error) {
+			return JoinContext(cid, "bash", mods[hop])
+		}
+		results, err := Chain(context.Background(), len(mods), join, cmdArgs, stdin, func(hop int, c *Context) io.Writer {
+			streamed.WriteString("[" + SigTag(c) + "]\n")
+			return &streamed
+		})
+		return results, streamed.String(), err
+	}
+
+	// Each answer is the next prompt: here, the command the next hop runs
+	results, streamed, err := chain([]string{"architect", "engineer", "architect"}, "cat", strings.NewReader(`echo "echo $AIMOD-done"`))
+	if err != nil {
+		t.Fatalf("Chain() error = %v", err)
+	}
+	wantStreamed := "[Architect Bash]\necho \"echo $AIMOD-done\"\n[Engineer Bash]\necho engineer-done\n[Architect Bash]\nengineer-done\n"
+	if len(results) != 3 || streamed != wantStreamed {
+		t.Fatalf("Chain() = %d results, streamed %q, want %q", len(results), streamed, wantStreamed)
+	}
+	if string(results[2].Output) != "engineer-done\n" {
+		t.Errorf("last output = %q", results[2].Output)
+	}
+
+	// Prompts carry their provenance; the repeated persona resumes its session
+	msgs, err := Transcript(cid, TranscriptFilter{MOD: "engineer"})
+	wantPrompt := "# From Architect Bash (chain 1/3, call " + string(results[0].Record.ID) + ")\n\necho \"echo $AIMOD-done\""
+	if err != nil || len(msgs) != 2 || msgs[0].Body != wantPrompt {
+		t.Errorf("engineer transcript = %+v, %v, want prompt %q", msgs, err, wantPrompt)
+	}
+	if results[2].Record.SIDBefore != results[0].Record.SIDAfter {
+		t.Errorf("second architect hop SID = %s, want %s", results[2].Record.SIDBefore, results[0].Record.SIDAfter)
+	}
+
+	// Nested in a persona's call, hops are that persona's calls; a blocked
+	// hop stops the chain
+	t.Setenv("AITAG", "architect~claude")
+	t.Setenv("AILVL", "1")
+	parent := results[0].Record.ID
+	t.Setenv("AICALL", string(parent))
+	results, _, err = chain([]string{"engineer", "reviewer", "engineer"}, "echo review this", nil)
+	var blockErr *BlockingError
+	if !errors.As(err, &blockErr) || blockErr.Code != 6 || len(results) != 2 {
+		t.Fatalf("Chain() = %d results, %v, want blocked at hop 2", len(results), err)
+	}
+	rec := results[0].Record
+	if rec.Top != "architect~claude" || rec.Tag != "engineer~bash" || rec.LVL != 1 || rec.Parent != parent {
+		t.Errorf("hop 1 record = %+v", rec)
+	}
+	if !results[1].Record.Blocked {
+		t.Errorf("hop 2 record not blocked: %+v", results[1].Record)
+	}
+	records, err := LoadCalls(cid)
+	if err != nil || len(records) != 5 {
+		t.Errorf("LoadCalls() = %d records, %v, want 5", len(records), err)
+	}
+}
@@ -0,0 +1,214 @@
+package aimux
+
+// chat.go - Chat history kept in the session logs for genera without backend sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// forkTagPrefix marks the first reply of a session forked from another:
+	// "fork:<parent SID>@<RFC3339Nano time the parent was forked at>"
+	forkTagPrefix = "fork:"
+
+	// forkEnvKey stores the fork tag of a call that branches a chat session
+	// in Context.ENV, for the decoder logging the reply.
+	forkEnvKey = "_AIMUX_FORK"
+)
+
+// ChatMessage is one message of the history sent to a chat API.
+type ChatMessage struct {
+	Role    string `json:"role"` // "user" or "assistant"
+	Content string `json:"content"`
+}
+
+// chatTurn is a prompt and the reply logged by the same call.
+type chatTurn struct {
+	prompt Message
+	reply  Message
+}
+
+// ChatHistory rebuilds the chat history of session sid from c's genus logs
+// (Log1 and Log3). A turn is a prompt and the reply logged by the same call
+// (matched by their "call:" tags), so failed calls leave no trace. Prompts
+// whose stdin was spilled to a sidecar file are replayed in full. A session
+// forked from another (see forkTagPrefix) starts with its parent's history up
+// to the fork. Turns answered after until (if non-zero) are left out.
+func ChatHistory(c *Context, sid ID, until time.Time) ([]ChatMessage, error) {
+	turns, err := chatTurns(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []ChatMessage
+	for _, t := range sessionTurns(turns, sid, until, map[ID]bool{}) {
+		history = append(history,
+			ChatMessage{Role: "user", Content: fullPrompt(t.prompt)},
+			ChatMessage{Role: "assistant", Content: t.reply.Body})
+	}
+	return history, nil
+}
+
+// chatTurns reads the completed turns logged in c's genus logs, ordered by
+// reply time.
+func chatTurns(c *Context) ([]chatTurn, error) {
+	log1, err := Log1(c)
+	if err != nil {
+		return nil, err
+	}
+	log3, err := Log3(c)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{log1}
+	if log3 != log1 {
+		paths = append(paths, log3)
+	}
+
+	prompts := map[string]Message{}
+	replies := map[string]Message{}
+	for _, path := range paths {
+		messages, err := loadMessagesFromLog(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			call := tagValue(msg.Tags, callTagPrefix)
+			switch {
+			case call == "":
+			case msg.From == "user":
+				prompts[call] = msg
+			case msg.From == "assistant":
+				replies[call] = msg
+			}
+		}
+	}
+
+	var turns []chatTurn
+	for call, reply := range replies {
+		if prompt, ok := prompts[call]; ok {
+			turns = append(turns, chatTurn{prompt: prompt, reply: reply})
+		}
+	}
+	sort.SliceStable(turns, func(i, j int) bool {
+		return turns[i].reply.At.Before(turns[j].reply.At)
+	})
+	return turns, nil
+}
+
+// sessionTurns selects the turns of session sid answered by until (if
+// non-zero), preceded by its parent's turns if it was forked.
+func sessionTurns(turns []chatTurn, sid ID, until time.Time, seen map[ID]bool) []chatTurn {
+	if seen[sid] {
+		return nil
+	}
+	seen[sid] = true
+
+	var own []chatTurn
+	for _, t := range turns {
+		if t.reply.SessionID == sid && (until.IsZero() || !t.reply.At.After(until)) {
+			own = append(own, t)
+		}
+	}
+	if len(own) == 0 {
+		return nil
+	}
+	parent, at, ok := parseForkTag(tagValue(own[0].reply.Tags, forkTagPrefix))
+	if !ok {
+		return own
+	}
+	if !until.IsZero() && until.Before(at) {
+		at = until
+	}
+	return append(sessionTurns(turns, parent, at, seen), own...)
+}
+
+// branchParent returns the session a branching call forks: c.SID when
+// rewinding (Rewind selected it) or when c's persona session is established,
+// otherwise the session last answered in Log1, which a persona joining the
+// conversation forks.
+func branchParent(c *Context) (ID, error) {
+	log2, err := Log2(c)
+	if err != nil {
+		return "", err
+	}
+	if c.ENV["AIRWD"] != "" || hasEstablishedSession(log2) {
+		return c.SID, nil
+	}
+	log1, err := Log1(c)
+	if err != nil {
+		return "", err
+	}
+	messages, err := loadMessagesFromLog(log1)
+	if err != nil {
+		return "", err
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].From != "user" && messages[i].SessionID != "" {
+			return messages[i].SessionID, nil
+		}
+	}
+	return c.SID, nil
+}
+
+// forkTag formats the fork tag value of a session forked from parent at at.
+func forkTag(parent ID, at time.Time) string {
+	return string(parent) + "@" + at.UTC().Format(time.RFC3339Nano)
+}
+
+// parseForkTag parses a fork tag value.
+func parseForkTag(value string) (ID, time.Time, bool) {
+	i := strings.LastIndex(value, "@")
+	if i < 0 {
+		return "", time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339Nano, value[i+1:])
+	if err != nil {
+		Warn("Ignoring malformed fork tag %q: %v", value, err)
+		return "", time.Time{}, false
+	}
+	return ID(value[:i]), at, true
+}
+
+// tagValue returns the value of the first tag with prefix, or empty string.
+func tagValue(tags []string, prefix string) string {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, prefix) {
+			return tag[len(prefix):]
+		}
+	}
+	return ""
+}
+
+// replyMessage marshals a complete assistant reply for the session log,
+// tagged with the call that produced it (and the fork it started, if any) so
+// ChatHistory can pair it with its prompt.
+func replyMessage(c *Context, body string) []byte {
+	var tags []string
+	if call := c.ENV[callEnvKey]; call != "" {
+		tags = append(tags, callTagPrefix+call)
+	}
+	if fork := c.ENV[forkEnvKey]; fork != "" {
+		tags = append(tags, forkTagPrefix+fork)
+	}
+	data, err := json.Marshal(Message{
+		SessionID: c.SID,
+		At:        time.Now(),
+		From:      "assistant",
+		Body:      body,
+		Tags:      tags,
+	})
+	if err != nil {
+		Warn("Failed to marshal reply: %v", err)
+		return nil
+	}
+	return data
+}
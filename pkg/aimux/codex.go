@@ -0,0 +1,126 @@
+package aimux
+
+// codex.go - Decoder for Codex CLI `exec --json` event streams
+
+import (
+	"bytes"
+)
+
+// codexDecoder decodes the JSONL events of `codex exec --json`:
+//
+//	{"type":"thread.started","thread_id":"..."}
+//	{"type":"item.completed","item":{"type":"agent_message","text":"..."}}
+//	{"type":"turn.completed","usage":{"input_tokens":...}}
+//
+// The thread_id becomes the session ID, resumed with `codex exec resume`. Like
+// Claude's session_id it is only adopted with the thread's first answer, so a
+// turn that fails leaves the session alone. Agent messages are output;
+// messages, reasoning and commands are logged as Message records in that
+// session, since Codex keeps its own session files. Older releases'
+// {"id":..., "msg":{...}} envelopes are decoded as well.
+type codexDecoder struct {
+	c     *Context
+	sid   ID    // Thread ID once started
+	usage Usage // Accumulated over turns
+}
+
+func (d *codexDecoder) Decode(line string) (StreamEvent, error) {
+	data, err := decodeJSONLine(line)
+	if data == nil {
+		return StreamEvent{}, err
+	}
+	if msg, ok := data["msg"].(map[string]interface{}); ok {
+		return d.decodeLegacy(msg), nil
+	}
+
+	switch msgType, _ := data["type"].(string); msgType {
+	case "thread.started":
+		id, _ := data["thread_id"].(string)
+		d.start(id)
+
+	case "turn.completed":
+		usage, _ := data["usage"].(map[string]interface{})
+		input := jsonInt(usage["input_tokens"])
+		cached := jsonInt(usage["cached_input_tokens"])
+		// input_tokens includes the cached tokens
+		d.usage.Add(Usage{
+			InputTokens:          input - cached,
+			CacheReadInputTokens: cached,
+			OutputTokens:         jsonInt(usage["output_tokens"]),
+			Turns:                1,
+		})
+		total := d.usage
+		return StreamEvent{SID: d.sid, Usage: &total}, nil
+
+	case "turn.failed", "error":
+		return d.failed(msgType, eventErrorMessage(data)), nil
+
+	case "item.completed":
+		item, _ := data["item"].(map[string]interface{})
+		text, _ := item["text"].(string)
+		switch item["type"] {
+		case "agent_message":
+			return StreamEvent{Text: text + "\n", Log: d.message("assistant", text), SID: d.sid}, nil
+		case "reasoning":
+			return StreamEvent{Log: d.message("reasoning", text)}, nil
+		case "command_execution":
+			command, _ := item["command"].(string)
+			output, _ := item["aggregated_output"].(string)
+			return StreamEvent{Log: bytes.Join([][]byte{
+				d.message("tool_use", command),
+				d.message("tool_result", output),
+			}, []byte("\n"))}, nil
+		case "error":
+			// Non-fatal: the turn continues
+			msg, _ := item["message"].(string)
+			Warn("Codex error item: %s", msg)
+		}
+	}
+	return StreamEvent{}, nil
+}
+
+// decodeLegacy decodes an {"id":..., "msg":{"type":...}} envelope.
+func (d *codexDecoder) decodeLegacy(msg map[string]interface{}) StreamEvent {
+	text, _ := msg["message"].(string)
+	switch msgType, _ := msg["type"].(string); msgType {
+	case "session_configured":
+		id, _ := msg["session_id"].(string)
+		d.start(id)
+	case "agent_message":
+		return StreamEvent{Text: text + "\n", Log: d.message("assistant", text), SID: d.sid}
+	case "agent_reasoning":
+		reasoning, _ := msg["text"].(string)
+		return StreamEvent{Log: d.message("reasoning", reasoning)}
+	case "error", "stream_error":
+		if text == "" {
+			text = "Unknown API error"
+		}
+		return d.failed(msgType, text)
+	}
+	return StreamEvent{}
+}
+
+// start records the backend's thread ID, to be adopted as the session ID.
+func (d *codexDecoder) start(id string) {
+	if !isValidUUID(id) {
+		Warn("Ignoring invalid Codex thread ID: %q", id)
+		return
+	}
+	d.sid = ID(id)
+}
+
+// failed reports an error event. Errors are output but not logged.
+func (d *codexDecoder) failed(msgType, text string) StreamEvent {
+	Warn("API error response (type=%s): %s", msgType, text)
+	return StreamEvent{Text: text + "\n", Failed: true}
+}
+
+// message marshals a log entry in the Codex thread (or the current session
+// before the thread has started).
+func (d *codexDecoder) message(from, body string) []byte {
+	sid := d.sid
+	if sid == "" {
+		sid = d.c.SID
+	}
+	return logMessage(sid, from, body)
+}
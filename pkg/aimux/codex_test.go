@@ -0,0 +1,143 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeCodex emulates `codex exec --json`: it records its argv and stdin,
+// starts (or resumes) thread 0199a213-81c0-7800-8aa1-bbab2a035a53 and answers
+// with a reasoning item, an agent message and a usage report. Prompts
+// containing "fail" fail the turn instead.
+const fakeCodex = `#!/bin/bash
+dir=$(dirname "$0")
+echo "$@" >> "$dir/argv"
+prompt=$(cat)
+printf '%s' "$prompt" > "$dir/stdin"
+echo '{"type":"thread.started","thread_id":"0199a213-81c0-7800-8aa1-bbab2a035a53"}'
+echo '{"type":"turn.started"}'
+if [[ "$prompt" == *fail* ]]; then
+  echo '{"type":"turn.failed","error":{"message":"model overloaded"}}'
+  exit 1
+fi
+echo '{"type":"item.completed","item":{"id":"item_0","type":"reasoning","text":"**Greeting**"}}'
+echo '{"type":"item.completed","item":{"id":"item_1","type":"agent_message","text":"Hi from codex"}}'
+echo '{"type":"turn.completed","usage":{"input_tokens":1200,"cached_input_tokens":1000,"output_tokens":30}}'
+`
+
+// TestCodexGenus verifies codex exec/resume invocation and thread ID capture
+func TestCodexGenus(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	t.Setenv("AICALL", "")
+
+	binDir := filepath.Join(tmpDir, "bin")
+	script := filepath.Join(binDir, "codex")
+	writeTestLog(t, script, fakeCodex)
+	if err := os.Chmod(script, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatalf("DefaultConfig() error = %v", err)
+	}
+	codex := cfg.Genera["codex"]
+	codex.Exe = []string{script}
+	data, err := json.Marshal(map[string]interface{}{"genera": map[string]GenusConfig{"codex": codex}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLog(t, filepath.Join(tmpDir, ".aimux", "config.json"), string(data))
+
+	thread := ID("0199a213-81c0-7800-8aa1-bbab2a035a53")
+	readArgv := func() []string {
+		data, err := os.ReadFile(filepath.Join(binDir, "argv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	ctx, err := InitContext("codex", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+
+	// First call starts a new thread and adopts its ID as the session
+	var out bytes.Buffer
+	rec, err := RunCall(context.Background(), ctx, "hello", nil, &out)
+	if err != nil {
+		t.Fatalf("RunCall() error = %v", err)
+	}
+	if got := out.String(); got != "Hi from codex\n" {
+		t.Errorf("RunCall() output = %q", got)
+	}
+	if want := "exec --json --skip-git-repo-check --model gpt-5-codex -c model_reasoning_effort=medium -"; readArgv()[0] != want {
+		t.Errorf("new call argv = %q, want %q", readArgv()[0], want)
+	}
+	if stdin, _ := os.ReadFile(filepath.Join(binDir, "stdin")); !strings.HasPrefix(string(stdin), "PARTNER PROTOCOL") || !strings.HasSuffix(string(stdin), "\n\nhello") {
+		t.Errorf("codex stdin = %q, want system prompt then prompt", stdin)
+	}
+	if ctx.SID != thread || rec.SIDAfter != thread {
+		t.Errorf("SID = %s (recorded %s), want thread %s", ctx.SID, rec.SIDAfter, thread)
+	}
+	want := Usage{InputTokens: 200, CacheReadInputTokens: 1000, OutputTokens: 30, Turns: 1}
+	if rec.Usage == nil || *rec.Usage != want {
+		t.Errorf("RunCall() usage = %+v, want %+v", rec.Usage, want)
+	}
+
+	// The thread is saved and resumed by later calls
+	resumed, err := ResumeContext(ctx.CID, "codex", "")
+	if err != nil {
+		t.Fatalf("ResumeContext() error = %v", err)
+	}
+	if resumed.SID != thread {
+		t.Errorf("ResumeContext() SID = %s, want %s", resumed.SID, thread)
+	}
+	if _, err := RunCall(context.Background(), resumed, "again", nil, &out); err != nil {
+		t.Fatalf("RunCall(resume) error = %v", err)
+	}
+	if got := readArgv()[1]; !strings.HasSuffix(got, " resume "+string(thread)+" -") {
+		t.Errorf("resume call argv = %q", got)
+	}
+
+	// The transcript shows the thread's reasoning, answers and resumed prompts
+	msgs, err := Transcript(ctx.CID, TranscriptFilter{SID: thread})
+	if err != nil {
+		t.Fatalf("Transcript() error = %v", err)
+	}
+	var froms []string
+	for _, msg := range msgs {
+		froms = append(froms, msg.From)
+	}
+	if got := strings.Join(froms, ","); got != "reasoning,assistant,user,reasoning,assistant" {
+		t.Errorf("Transcript() = %s", got)
+	}
+
+	// Failed turns are reported and leave the session alone
+	failing, err := InitContext("codex", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+	out.Reset()
+	rec, err = RunCall(context.Background(), failing, "please fail", nil, &out)
+	if err == nil || rec.Exit != 1 {
+		t.Errorf("RunCall(fail) err = %v, exit %d", err, rec.Exit)
+	}
+	if got := out.String(); got != "model overloaded\n" {
+		t.Errorf("RunCall(fail) output = %q", got)
+	}
+	if failing.SID == thread {
+		t.Errorf("failed call adopted thread %s", thread)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 )
 
@@ -18,6 +19,9 @@ const (
 	templatesDir = "templates"
 	hintsDir     = "hints"
 	configFile   = "config.json"
+
+	// defaultMaxDepth is the call depth limit when config sets none
+	defaultMaxDepth = 3
 )
 
 // PersonaConfig defines a persona's model preferences and behavioral hints.
@@ -27,15 +31,24 @@ type PersonaConfig struct {
 	Model2     string   `json:"model2"`
 	Hints      []string `json:"hints"`
 	Delegatees []string `json:"delegatees"`
+	MaxDepth   int      `json:"max_depth,omitempty"`
+	Budget     *Budget  `json:"budget,omitempty"`
 }
 
 // GenusConfig defines an AI provider's executable, command prefix, args, and persona mappings.
+// HTTP genera (Type other than exec) set URL and APIKeyEnv instead of Exe, Cmd and Args.
 type GenusConfig struct {
-	Name     string                 `json:"name"`
-	Exe      []string               `json:"exe"`
-	Cmd      []string               `json:"cmd"`
-	Args     GenusArgs              `json:"args"`
-	Personas map[string]PersonaVars `json:"personas"`
+	Name      string                 `json:"name"`
+	Type      string                 `json:"type,omitempty"`
+	Exe       []string               `json:"exe"`
+	Cmd       []string               `json:"cmd"`
+	Args      GenusArgs              `json:"args"`
+	URL       string                 `json:"url,omitempty"`
+	APIKeyEnv string                 `json:"api_key_env,omitempty"`
+	Format    string                 `json:"format,omitempty"`
+	Personas  map[string]PersonaVars `json:"personas"`
+	MaxDepth  int                    `json:"max_depth,omitempty"`
+	Retry     *RetryPolicy           `json:"retry,omitempty"`
 }
 
 // GenusArgs defines CLI argument templates for different session modes.
@@ -52,10 +65,38 @@ type GenusArgs struct {
 // PersonaVars holds variable substitutions for flag template rendering.
 type PersonaVars map[string]string
 
+// PolicyRule matches a call on caller (TOP) and callee (MOD/GEN) identity and
+// decides whether it may proceed. Unset fields match anything; see matchPattern
+// for the pattern syntax of the string fields.
+type PolicyRule struct {
+	Name         string `json:"name"`
+	Action       string `json:"action"`
+	Top          string `json:"top,omitempty"`
+	TopGen       string `json:"top_gen,omitempty"`
+	Mod          string `json:"mod,omitempty"`
+	Gen          string `json:"gen,omitempty"`
+	MinLvl       *int   `json:"min_lvl,omitempty"`
+	MaxLvl       *int   `json:"max_lvl,omitempty"`
+	Self         bool   `json:"self,omitempty"`
+	OverDepth    bool   `json:"over_depth,omitempty"`
+	NotDelegatee bool   `json:"not_delegatee,omitempty"`
+	Code         int    `json:"code,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+// PolicyConfig holds ordered call-policy rules; the first matching rule wins.
+type PolicyConfig struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
 // Config holds the complete configuration with personas and genera.
 type Config struct {
 	Personas map[string]PersonaConfig `json:"personas"`
 	Genera   map[string]GenusConfig   `json:"genera"`
+	Policy   PolicyConfig             `json:"policy"`
+	MaxDepth int                      `json:"max_depth,omitempty"`
+	StdinCap int                      `json:"stdin_cap,omitempty"`
+	Budgets  BudgetConfig             `json:"budgets,omitempty"`
 }
 
 // DefaultConfig returns built-in configuration parsed from embedded config.json.
@@ -107,7 +148,7 @@ func LoadConfig() (*Config, error) {
 
 	// Auto-generate config.json if missing
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		if err := os.WriteFile(configPath, defaultConfigJSON, 0o644); err != nil {
+		if err := writeFileAtomic(configPath, defaultConfigJSON); err != nil {
 			Warn("Failed to create default config, using defaults: %v", err)
 			return DefaultConfig()
 		}
@@ -138,14 +179,92 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 	for k, v := range defaults.Genera {
-		if _, exists := cfg.Genera[k]; !exists {
+		if g, exists := cfg.Genera[k]; !exists {
 			cfg.Genera[k] = v
+		} else {
+			// A genus copied unchanged from an earlier release's defaults
+			// moves to the current ones
+			if isLegacyGenus(k, g) {
+				g = v
+			}
+			// Config files written before genus formats existed; the format
+			// only fits the default genus's command line
+			if g.Format == "" && sameCommand(g, v) {
+				g.Format = v.Format
+			}
+			// A genus copied unchanged from the defaults before retry
+			// policies existed retries by default too; one edited since is
+			// the user's to change
+			if g.Retry == nil && sameGenus(g, v) {
+				g.Retry = v.Retry
+			}
+			cfg.Genera[k] = g
 		}
 	}
+	// Policy rules are ordered, so a config file policy replaces the default wholesale
+	if cfg.Policy.Rules == nil {
+		cfg.Policy.Rules = defaults.Policy.Rules
+	}
+	if cfg.MaxDepth <= 0 {
+		cfg.MaxDepth = defaults.MaxDepth
+	}
+	if cfg.StdinCap <= 0 {
+		cfg.StdinCap = defaults.StdinCap
+	}
 
 	return &cfg, nil
 }
 
+// legacyGeneraJSON holds default genera as earlier releases auto-wrote them
+// to config.json, before their defaults changed.
+const legacyGeneraJSON = `{
+  "codex": [{
+    "name": "codex",
+    "exe": ["codex"],
+    "cmd": [],
+    "args": {
+      "model": ["--model", "{{model}}", "-c", "model_reasoning_effort={{effort}}"],
+      "resume": ["resume", "{{sid}}"],
+      "new": ["{{sid}}"],
+      "prompt": "stdin",
+      "output": [],
+      "safety": []
+    },
+    "personas": {
+      "": {"model": "gpt-5-codex", "effort": "medium"},
+      "customer": {"model": "gpt-5-codex", "effort": "low"}
+    }
+  }]
+}`
+
+// isLegacyGenus returns true if genus g, named name, is one of the default
+// genera an earlier release wrote, unedited since.
+func isLegacyGenus(name string, g GenusConfig) bool {
+	var legacy map[string][]GenusConfig
+	if err := json.Unmarshal([]byte(legacyGeneraJSON), &legacy); err != nil {
+		Warn("Invalid legacy genera: %v", err)
+		return false
+	}
+	for _, l := range legacy[name] {
+		if sameGenus(g, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameCommand reports whether genera a and b run the same command line.
+func sameCommand(a, b GenusConfig) bool {
+	return reflect.DeepEqual(a.Exe, b.Exe) && reflect.DeepEqual(a.Cmd, b.Cmd) && reflect.DeepEqual(a.Args, b.Args)
+}
+
+// sameGenus reports whether genera a and b are configured alike, apart from
+// their retry policies.
+func sameGenus(a, b GenusConfig) bool {
+	a.Retry, b.Retry = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
 // GetGenus returns genus configuration by name
 func (c *Config) GetGenus(gen string) (GenusConfig, bool) {
 	g, ok := c.Genera[gen]
@@ -188,6 +307,40 @@ func RenderFlags(template []string, vars map[string]string) []string {
 	return result
 }
 
+// DepthLimit returns the maximum call depth for calls to persona mod of genus gen.
+// A persona max_depth overrides the global max_depth; a genus max_depth can only
+// lower the result (e.g. capping bash at 1 regardless of persona).
+func (cfg *Config) DepthLimit(gen, mod string) int {
+	limit := cfg.MaxDepth
+	if limit <= 0 {
+		limit = defaultMaxDepth
+	}
+	if p, ok := cfg.Personas[mod]; ok && p.MaxDepth > 0 {
+		limit = p.MaxDepth
+	}
+	if g, ok := cfg.Genera[gen]; ok && g.MaxDepth > 0 && g.MaxDepth < limit {
+		limit = g.MaxDepth
+	}
+	return limit
+}
+
+// CanDelegate reports whether persona caller may call persona callee.
+// Callers that are unknown or have no delegatees configured (null) are
+// unrestricted; an empty delegatees list forbids all calls. An undifferentiated
+// callee (empty) is allowed only by an "" entry.
+func (cfg *Config) CanDelegate(caller, callee string) bool {
+	r, ok := cfg.Personas[caller]
+	if !ok || r.Delegatees == nil {
+		return true
+	}
+	for _, d := range r.Delegatees {
+		if d == callee {
+			return true
+		}
+	}
+	return false
+}
+
 // GetPersonaHints returns hints for a persona from config
 func (cfg *Config) GetPersonaHints(persona string) []string {
 	if r, ok := cfg.Personas[persona]; ok {
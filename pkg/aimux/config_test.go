@@ -1,6 +1,11 @@
 package aimux
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -130,3 +135,162 @@ func TestGenusArgs(t *testing.T) {
 		t.Error("Expected codex prompt to be 'stdin'")
 	}
 }
+
+func TestCanDelegate(t *testing.T) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatalf("DefaultConfig() failed: %v", err)
+	}
+	cfg.Personas["freeform"] = PersonaConfig{Name: "freeform"}
+	cfg.Personas["generalist"] = PersonaConfig{Name: "generalist", Delegatees: []string{"", "qa"}}
+
+	tests := []struct {
+		caller, callee string
+		want           bool
+	}{
+		{"architect", "engineer", true},
+		{"architect", "customer", false},
+		{"architect", "", false},
+		{"customer", "architect", true},
+		{"engineer", "architect", false},
+		{"freeform", "anyone", true},
+		{"generalist", "", true},
+		{"generalist", "architect", false},
+		{"unknown", "engineer", true},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.CanDelegate(tt.caller, tt.callee); got != tt.want {
+			t.Errorf("CanDelegate(%q, %q) = %v, want %v", tt.caller, tt.callee, got, tt.want)
+		}
+	}
+}
+
+func TestDepthLimit(t *testing.T) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatalf("DefaultConfig() failed: %v", err)
+	}
+
+	architect := cfg.Personas["architect"]
+	architect.MaxDepth = 4
+	cfg.Personas["architect"] = architect
+
+	bash := cfg.Genera["bash"]
+	bash.MaxDepth = 1
+	cfg.Genera["bash"] = bash
+
+	tests := []struct {
+		gen, mod string
+		want     int
+	}{
+		{"claude", "", 3},
+		{"claude", "engineer", 3},
+		{"claude", "architect", 4},
+		{"bash", "", 1},
+		{"bash", "architect", 1},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.DepthLimit(tt.gen, tt.mod); got != tt.want {
+			t.Errorf("DepthLimit(%q, %q) = %d, want %d", tt.gen, tt.mod, got, tt.want)
+		}
+	}
+
+	// Unset global falls back to the built-in default
+	cfg.MaxDepth = 0
+	if got := cfg.DepthLimit("claude", ""); got != defaultMaxDepth {
+		t.Errorf("DepthLimit() with unset max_depth = %d, want %d", got, defaultMaxDepth)
+	}
+}
+
+func TestLoadConfigRetryDefaults(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	defaults, err := DefaultConfig()
+	if err != nil {
+		t.Fatalf("DefaultConfig() failed: %v", err)
+	}
+	// Copies of the built-in genera from before retry policies existed, one
+	// of them edited since
+	unchanged, edited := defaults.Genera["openai"], defaults.Genera["anthropic"]
+	unchanged.Retry, edited.Retry = nil, nil
+	edited.URL = "http://localhost:8000/v1"
+	data, err := json.Marshal(map[string]interface{}{"genera": map[string]GenusConfig{"openai": unchanged, "anthropic": edited}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLog(t, filepath.Join(tmpDir, ".aimux", "config.json"), string(data))
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if g := cfg.Genera["openai"]; g.Retry == nil || g.Retry.MaxAttempts != defaults.Genera["openai"].Retry.MaxAttempts {
+		t.Errorf("unchanged genus retry = %+v, want the default", g.Retry)
+	}
+	if g := cfg.Genera["anthropic"]; g.Retry != nil {
+		t.Errorf("edited genus retry = %+v, want none", g.Retry)
+	}
+}
+
+func TestLoadConfigLegacyCodex(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	// The codex genus as config.json was auto-written before exec --json,
+	// and a copy of it edited since
+	legacy := `{
+      "name": "codex",
+      "exe": ["codex"],
+      "cmd": [],
+      "args": {
+        "model": ["--model", "{{model}}", "-c", "model_reasoning_effort={{effort}}"],
+        "resume": ["resume", "{{sid}}"],
+        "new": ["{{sid}}"],
+        "prompt": "stdin",
+        "output": [],
+        "safety": []
+      },
+      "personas": {
+        "": {"model": "gpt-5-codex", "effort": "medium"},
+        "customer": {"model": "gpt-5-codex", "effort": "low"}
+      }
+    }`
+	edited := strings.Replace(legacy, `"effort": "low"`, `"effort": "high"`, 1)
+
+	defaults, err := DefaultConfig()
+	if err != nil {
+		t.Fatalf("DefaultConfig() failed: %v", err)
+	}
+	tests := []struct {
+		name     string
+		genus    string
+		migrated bool
+	}{
+		{"unedited", legacy, true},
+		{"edited", edited, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeTestLog(t, filepath.Join(tmpDir, ".aimux", "config.json"), `{"genera": {"codex": `+tt.genus+`}}`)
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatalf("LoadConfig() failed: %v", err)
+			}
+			g := cfg.Genera["codex"]
+			if tt.migrated && !reflect.DeepEqual(g, defaults.Genera["codex"]) {
+				t.Errorf("codex genus = %+v, want the default %+v", g, defaults.Genera["codex"])
+			}
+			// Its old command line prints no JSON for the codex decoder
+			if !tt.migrated && (g.Format != "" || len(g.Cmd) != 0) {
+				t.Errorf("codex genus = %+v, want it as written", g)
+			}
+		})
+	}
+}
@@ -0,0 +1,560 @@
+package aimux
+
+// daemon.go - `aimux serve`: one long-running process making the calls of
+// every aimux client, through a JSON-RPC API on a Unix socket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// socketEnvVar overrides the daemon's socket path
+	socketEnvVar = "AISOCK"
+
+	// socketFile is the daemon's default socket (under ~/.aimux)
+	socketFile = "aimux.sock"
+
+	// outputMethod is the notification streaming a call's output to the client
+	outputMethod = "output"
+)
+
+// JSON-RPC 2.0 error codes used by the daemon.
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcCallFailed     = -32000 // The call ran and failed; data is its callResult
+)
+
+// rpcMessage is a JSON-RPC 2.0 request, response or notification. The API
+// sends one per line, and one request per connection: the server answers
+// with any output notifications, then the response.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is a JSON-RPC 2.0 error object.
+type rpcError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// remoteError is the error of a call the daemon made, with its exit code.
+type remoteError struct {
+	Message string
+	Exit    int
+}
+
+func (e *remoteError) Error() string {
+	return e.Message
+}
+
+// Daemon method parameters and results.
+type (
+	callParams struct {
+		Call    ID       `json:"call,omitempty"` // Assigned by the daemon if empty
+		Parent  ID       `json:"parent,omitempty"`
+		Context *Context `json:"context"`
+		Prompt  string   `json:"prompt"`
+		Stdin   []byte   `json:"stdin"`         // Null without stdin
+		Dir     string   `json:"dir,omitempty"` // The genus's working directory, the daemon's if empty
+		Env     []string `json:"env,omitempty"` // The genus's environment, the daemon's if null
+	}
+	callResult struct {
+		Record  *CallRecord `json:"record"`
+		Context *Context    `json:"context"` // As the call left it
+	}
+	fanoutParams struct {
+		Parent   ID         `json:"parent,omitempty"`
+		Contexts []*Context `json:"contexts"`
+		Prompt   string     `json:"prompt"`
+		Stdin    []byte     `json:"stdin"`
+		Parallel int        `json:"parallel"`
+		Dir      string     `json:"dir,omitempty"`
+		Env      []string   `json:"env,omitempty"`
+	}
+	fanoutCall struct {
+		Context *Context    `json:"context"`
+		Record  *CallRecord `json:"record"`
+		Output  []byte      `json:"output"`
+		Error   string      `json:"error,omitempty"`
+	}
+	showParams struct {
+		CID   ID        `json:"cid"`
+		GEN   string    `json:"gen,omitempty"`
+		MOD   string    `json:"mod,omitempty"`
+		SID   ID        `json:"sid,omitempty"`
+		Since time.Time `json:"since"`
+		Until time.Time `json:"until"`
+	}
+	cancelParams struct {
+		Call ID `json:"call"`
+	}
+	cancelResult struct {
+		Canceled bool `json:"canceled"`
+	}
+)
+
+// daemonMethod handles one request; out streams call output to the client.
+type daemonMethod func(ctx context.Context, params json.RawMessage, out io.Writer) (interface{}, error)
+
+// daemonMethods is the daemon API, by JSON-RPC method name.
+var daemonMethods = map[string]daemonMethod{
+	"Call":   serveCall,
+	"Fanout": serveFanout,
+	"List":   serveList,
+	"Show":   serveShow,
+	"Cancel": serveCancel,
+}
+
+// SocketPath returns the daemon's socket: $AISOCK, or ~/.aimux/aimux.sock.
+func SocketPath() (string, error) {
+	if path := os.Getenv(socketEnvVar); path != "" {
+		return path, nil
+	}
+	home, err := homeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, aimuxDir, socketFile), nil
+}
+
+// Serve runs the daemon on the Unix socket at path until ctx is done, making
+// calls for clients (see Client) in this process. Calls still running when
+// ctx is done are canceled. The socket is only accessible to its owner.
+func Serve(ctx context.Context, path string) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("a daemon is already listening on %s", path)
+	}
+	os.Remove(path) // Left by a daemon that did not exit cleanly
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Create the socket accessible to its owner only, rather than chmod it
+	// after Listen and leave it open to other users meanwhile
+	umask := syscall.Umask(0o177)
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return err
+	}
+	defer ln.Close() // Removes the socket
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveConn(ctx, conn)
+		}()
+	}
+}
+
+// serveConn answers the request on conn. The request's calls are canceled
+// if the client hangs up first. Connections from other users are refused.
+func serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	uid, err := peerUID(conn)
+	if err != nil {
+		Warn("Daemon refused a connection: %v", err)
+		return
+	}
+	if uid != os.Getuid() {
+		Warn("Daemon refused a connection from user %d", uid)
+		return
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return // Probed by DialDaemon, or gone
+	}
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(conn)
+	send := func(msg rpcMessage) {
+		msg.JSONRPC = "2.0"
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(msg); err != nil {
+			Debug("Daemon failed to write to client: %v", err)
+		}
+	}
+
+	var req rpcMessage
+	if err := json.Unmarshal(line, &req); err != nil {
+		send(rpcMessage{Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+		return
+	}
+	method, ok := daemonMethods[req.Method]
+	if !ok {
+		send(rpcMessage{ID: req.ID, Error: &rpcError{Code: rpcMethodNotFound, Message: "unknown method " + req.Method}})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		io.Copy(io.Discard, r) // Returns once the client hangs up
+		cancel()
+	}()
+
+	out := writerFunc(func(p []byte) (int, error) {
+		params, err := json.Marshal(struct {
+			Data []byte `json:"data"`
+		}{p})
+		if err != nil {
+			return 0, err
+		}
+		send(rpcMessage{Method: outputMethod, Params: params})
+		return len(p), nil
+	})
+	Debug("Daemon serving %s", req.Method)
+	result, err := method(ctx, req.Params, out)
+	if err != nil {
+		var rerr *rpcError
+		if !errors.As(err, &rerr) {
+			rerr = &rpcError{Code: rpcInternalError, Message: err.Error()}
+		}
+		send(rpcMessage{ID: req.ID, Error: rerr})
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		send(rpcMessage{ID: req.ID, Error: &rpcError{Code: rpcInternalError, Message: err.Error()}})
+		return
+	}
+	send(rpcMessage{ID: req.ID, Result: data})
+}
+
+// writerFunc adapts a function to io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// decodeParams decodes params into v, as an invalid params error.
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// stdinReader returns a reader of stdin as sent by a client, nil for none.
+func stdinReader(stdin []byte) io.Reader {
+	if stdin == nil {
+		return nil
+	}
+	return bytes.NewReader(stdin)
+}
+
+func serveCall(ctx context.Context, params json.RawMessage, out io.Writer) (interface{}, error) {
+	var p callParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Context == nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "missing context"}
+	}
+	if p.Call == "" {
+		var err error
+		if p.Call, err = NewID(); err != nil {
+			return nil, err
+		}
+	}
+	p.Context.workDir, p.Context.environ = p.Dir, p.Env
+	rec, err := runCall(ctx, p.Context, p.Call, p.Parent, p.Prompt, stdinReader(p.Stdin), out)
+	res := callResult{Record: rec, Context: p.Context}
+	if err != nil {
+		data, merr := json.Marshal(res)
+		if merr != nil {
+			return nil, merr
+		}
+		return nil, &rpcError{Code: rpcCallFailed, Message: err.Error(), Data: data}
+	}
+	return res, nil
+}
+
+func serveFanout(ctx context.Context, params json.RawMessage, out io.Writer) (interface{}, error) {
+	var p fanoutParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	for _, c := range p.Contexts {
+		if c == nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "missing context"}
+		}
+		c.workDir, c.environ = p.Dir, p.Env
+	}
+	results, err := fanout(ctx, p.Contexts, p.Parent, p.Prompt, stdinReader(p.Stdin), p.Parallel)
+	if err != nil {
+		return nil, err
+	}
+	calls := make([]fanoutCall, len(results))
+	for i, r := range results {
+		calls[i] = fanoutCall{Context: r.Context, Record: r.Record, Output: r.Output}
+		if r.Err != nil {
+			calls[i].Error = r.Err.Error()
+		}
+	}
+	return calls, nil
+}
+
+func serveList(ctx context.Context, params json.RawMessage, out io.Writer) (interface{}, error) {
+	return ListConversations()
+}
+
+func serveShow(ctx context.Context, params json.RawMessage, out io.Writer) (interface{}, error) {
+	var p showParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return Transcript(p.CID, TranscriptFilter{GEN: p.GEN, MOD: p.MOD, SID: p.SID, Since: p.Since, Until: p.Until})
+}
+
+func serveCancel(ctx context.Context, params json.RawMessage, out io.Writer) (interface{}, error) {
+	var p cancelParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	return cancelResult{Canceled: CancelCall(p.Call)}, nil
+}
+
+// Client makes calls through a running daemon (see Serve). Its methods
+// mirror the package functions of the same name.
+type Client struct {
+	path string
+}
+
+// DialDaemon returns a Client of the daemon listening on SocketPath, or nil
+// if there is none.
+func DialDaemon() *Client {
+	path, err := SocketPath()
+	if err != nil {
+		return nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil
+	}
+	conn.Close()
+	return &Client{path: path}
+}
+
+// request sends method with params to the daemon and decodes its result into
+// result, passing any output notifications to out. The daemon cancels the
+// request if ctx is done before it answers.
+func (cl *Client) request(ctx context.Context, method string, params, result interface{}, out io.Writer) error {
+	conn, err := net.Dial("unix", cl.path)
+	if err != nil {
+		return fmt.Errorf("connect to daemon: %w", err)
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := json.Marshal(rpcMessage{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method, Params: data})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(append(req, '\n')); err != nil {
+		return fmt.Errorf("send to daemon: %w", err)
+	}
+
+	dec := json.NewDecoder(conn)
+	for {
+		var msg rpcMessage
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("read from daemon: %w", err)
+		}
+		if msg.Method == outputMethod {
+			var note struct {
+				Data []byte `json:"data"`
+			}
+			if err := json.Unmarshal(msg.Params, &note); err != nil {
+				return fmt.Errorf("read from daemon: %w", err)
+			}
+			if out != nil {
+				if _, err := out.Write(note.Data); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		return json.Unmarshal(msg.Result, result)
+	}
+}
+
+// readStdin reads stdin fully for a request, nil for none.
+func readStdin(stdin io.Reader) ([]byte, error) {
+	if stdin == nil {
+		return nil, nil
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("read stdin: %w", err)
+	}
+	return data, nil
+}
+
+// callerProcess returns the working directory and environment of this
+// process, for the daemon to run its calls in.
+func callerProcess() (string, []string) {
+	dir, err := os.Getwd()
+	if err != nil {
+		Debug("Cannot send working directory to daemon: %v", err)
+	}
+	return dir, os.Environ()
+}
+
+// callError returns the error of a call the daemon made from its message
+// and record, so ExitCode reports the call's exit code.
+func callError(message string, rec *CallRecord) error {
+	if rec == nil {
+		return errors.New(message)
+	}
+	if rec.Blocked {
+		return &BlockingError{Code: rec.Exit, Message: message}
+	}
+	return &remoteError{Message: message, Exit: rec.Exit}
+}
+
+// Call is RunCall made by the daemon, with its output streamed to w, in
+// this process's working directory and environment. id is the call's ID (see
+// Cancel), generated if empty. Stdin is read fully before the call starts.
+// c is updated as the call left it.
+func (cl *Client) Call(ctx context.Context, c *Context, id ID, cmdArgs string, stdin io.Reader, w io.Writer) (*CallRecord, error) {
+	input, err := readStdin(stdin)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		if id, err = NewID(); err != nil {
+			return nil, err
+		}
+	}
+	params := callParams{Call: id, Parent: ID(os.Getenv(callEnvVar)), Context: c, Prompt: cmdArgs, Stdin: input}
+	params.Dir, params.Env = callerProcess()
+	var res callResult
+	err = cl.request(ctx, "Call", params, &res, w)
+	var rerr *rpcError
+	if errors.As(err, &rerr) && rerr.Code == rpcCallFailed {
+		if jerr := json.Unmarshal(rerr.Data, &res); jerr != nil {
+			return nil, fmt.Errorf("read from daemon: %w", jerr)
+		}
+		err = callError(rerr.Message, res.Record)
+	}
+	if res.Context != nil {
+		*c = *res.Context
+	}
+	return res.Record, err
+}
+
+// Fanout is Fanout made by the daemon. Results have the contexts passed in,
+// updated as their calls left them.
+func (cl *Client) Fanout(ctx context.Context, contexts []*Context, cmdArgs string, stdin io.Reader, parallel int) ([]CallResult, error) {
+	input, err := readStdin(stdin)
+	if err != nil {
+		return nil, err
+	}
+	params := fanoutParams{Parent: ID(os.Getenv(callEnvVar)), Contexts: contexts, Prompt: cmdArgs, Stdin: input, Parallel: parallel}
+	params.Dir, params.Env = callerProcess()
+	var calls []fanoutCall
+	if err := cl.request(ctx, "Fanout", params, &calls, nil); err != nil {
+		return nil, err
+	}
+	if len(calls) != len(contexts) {
+		return nil, fmt.Errorf("daemon returned %d results for %d calls", len(calls), len(contexts))
+	}
+	results := make([]CallResult, len(calls))
+	for i, call := range calls {
+		if call.Context != nil {
+			*contexts[i] = *call.Context
+		}
+		results[i] = CallResult{Context: contexts[i], Record: call.Record, Output: call.Output}
+		if call.Error != "" {
+			results[i].Err = callError(call.Error, call.Record)
+		}
+	}
+	return results, nil
+}
+
+// List is ListConversations read by the daemon.
+func (cl *Client) List() ([]ConversationInfo, error) {
+	var infos []ConversationInfo
+	err := cl.request(context.Background(), "List", struct{}{}, &infos, nil)
+	return infos, err
+}
+
+// Show is Transcript read by the daemon.
+func (cl *Client) Show(cid ID, filter TranscriptFilter) ([]TranscriptMessage, error) {
+	params := showParams{CID: cid, GEN: filter.GEN, MOD: filter.MOD, SID: filter.SID, Since: filter.Since, Until: filter.Until}
+	var msgs []TranscriptMessage
+	err := cl.request(context.Background(), "Show", params, &msgs, nil)
+	return msgs, err
+}
+
+// Cancel cancels call id in the daemon, returning false if the daemon is
+// not running it.
+func (cl *Client) Cancel(id ID) (bool, error) {
+	var res cancelResult
+	err := cl.request(context.Background(), "Cancel", cancelParams{Call: id}, &res, nil)
+	return res.Canceled, err
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package aimux
+
+import (
+	"net"
+	"os"
+)
+
+// peerUID returns the user ID of the process at the other end of conn. The
+// peer's credentials are not checked on this platform; the socket's owner-only
+// permissions keep other users out, so the peer is taken to be this user.
+func peerUID(conn net.Conn) (int, error) {
+	return os.Getuid(), nil
+}
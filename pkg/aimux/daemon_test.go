@@ -0,0 +1,183 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDaemon(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	parent, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AICALL", string(parent))
+
+	sock := filepath.Join(tmpDir, "d.sock")
+	t.Setenv("AISOCK", sock)
+	if DialDaemon() != nil {
+		t.Fatal("DialDaemon() found a daemon before Serve")
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, sock) }()
+	var client *Client
+	for i := 0; i < 100 && client == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		client = DialDaemon()
+	}
+	if client == nil {
+		t.Fatal("DialDaemon() found no daemon")
+	}
+	if err := Serve(context.Background(), sock); err == nil {
+		t.Error("second Serve() on the same socket succeeded")
+	}
+
+	// Only its owner can connect
+	if info, err := os.Stat(sock); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, want 0600", info.Mode().Perm())
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, err := peerUID(conn); err != nil || uid != os.Getuid() {
+		t.Errorf("peerUID() = %d, %v, want %d", uid, err, os.Getuid())
+	}
+	conn.Close()
+
+	cid, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	join := func(mod string) *Context {
+		c, err := JoinContext(cid, "bash", mod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// Output streams back; the call is the client's, recorded by the daemon
+	c := join("architect")
+	var out bytes.Buffer
+	rec, err := client.Call(context.Background(), c, "", "cat; echo $AIMOD", strings.NewReader("hi\n"), &out)
+	if err != nil || out.String() != "hi\narchitect\n" {
+		t.Fatalf("Call() = %q, %v", out.String(), err)
+	}
+	if rec.Parent != parent || rec.Tag != "architect~bash" || c.SID != rec.SIDAfter {
+		t.Errorf("Call() record = %+v, context SID %s", rec, c.SID)
+	}
+
+	// The genus runs in the client's directory and environment, not the daemon's
+	work := filepath.Join(tmpDir, "work")
+	if err := os.Mkdir(work, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	params := callParams{Context: join("customer"), Prompt: `pwd; echo "$CLIENT_VAR"`, Dir: work, Env: []string{"CLIENT_VAR=client"}}
+	if err := client.request(context.Background(), "Call", params, &callResult{}, &out); err != nil || out.String() != work+"\nclient\n" {
+		t.Errorf("Call in client's process = %q, %v", out.String(), err)
+	}
+	out.Reset()
+	if _, err := client.Call(context.Background(), join("customer"), "", "pwd", nil, &out); err != nil || out.String() != mustGetwd(t)+"\n" {
+		t.Errorf("Call() ran in %q, %v", out.String(), err)
+	}
+
+	// Failures keep their exit codes
+	c.LVL = 3
+	var blockErr *BlockingError
+	if _, err := client.Call(context.Background(), c, "", "echo too deep", nil, &out); !errors.As(err, &blockErr) || ExitCode(err) != 3 {
+		t.Errorf("Call() error = %v, want blocked with code 3", err)
+	}
+	c.LVL = 0
+	if rec, err := client.Call(context.Background(), c, "", "exit 4", nil, &out); ExitCode(err) != 4 || rec.Exit != 4 {
+		t.Errorf("Call() error = %v (exit %d), want exit 4", err, ExitCode(err))
+	}
+
+	results, err := client.Fanout(context.Background(), []*Context{join("engineer"), join("reviewer")}, "echo $AIMOD: $(cat)", strings.NewReader("shared"), 0)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Fanout() = %+v, %v", results, err)
+	}
+	for i, want := range []string{"engineer: shared\n", "reviewer: shared\n"} {
+		if r := results[i]; r.Err != nil || string(r.Output) != want || r.Record.Parent != parent {
+			t.Errorf("Fanout() result %d = %q, %v", i, r.Output, r.Err)
+		}
+	}
+
+	infos, err := client.List()
+	if err != nil || len(infos) != 1 || infos[0].CID != cid {
+		t.Errorf("List() = %+v, %v", infos, err)
+	}
+	msgs, err := client.Show(cid, TranscriptFilter{MOD: "engineer"})
+	if err != nil || len(msgs) != 2 || msgs[1].Body != "engineer: shared" {
+		t.Errorf("Show() = %+v, %v", msgs, err)
+	}
+
+	// A running call can be canceled by the ID the client gave it (AICALL
+	// within the call)
+	id, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	callFile := filepath.Join(tmpDir, "call")
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), join("qa"), id, "echo $AICALL > "+callFile+"; sleep 10", nil, &out)
+		canceled <- err
+	}()
+	var call []byte
+	for i := 0; i < 200 && len(call) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		call, _ = os.ReadFile(callFile)
+	}
+	if got := ID(strings.TrimSpace(string(call))); got != id {
+		t.Errorf("call ran as AICALL=%s, want %s", got, id)
+	}
+	if ok, err := client.Cancel(id); !ok || err != nil {
+		t.Errorf("Cancel() = %v, %v", ok, err)
+	}
+	select {
+	case err := <-canceled:
+		if err == nil {
+			t.Error("canceled Call() succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Call() still running after Cancel()")
+	}
+	if ok, err := client.Cancel(parent); ok || err != nil {
+		t.Errorf("Cancel() of no running call = %v, %v", ok, err)
+	}
+
+	stop()
+	if err := <-served; err != nil {
+		t.Errorf("Serve() error = %v", err)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket left behind: %v", err)
+	}
+}
+
+// mustGetwd returns the working directory of the test.
+func mustGetwd(t *testing.T) string {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
@@ -0,0 +1,305 @@
+package aimux
+
+// decode.go - Per-genus decoders for genus output streams
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stream formats selectable with a genus's "format".
+const (
+	StreamFormatClaude    = "claude"    // Claude CLI stream-json
+	StreamFormatCodex     = "codex"     // Codex CLI JSONL events
+	StreamFormatText      = "text"      // Plain text, logged line by line
+	StreamFormatXML       = "xml"       // XML-tagged text, logged with markup stripped
+	StreamFormatOpenAI    = "openai"    // OpenAI chat completion chunks (server-sent events)
+	StreamFormatOllama    = "ollama"    // Ollama chat chunks (NDJSON)
+	StreamFormatAnthropic = "anthropic" // Anthropic Messages API events (server-sent events)
+)
+
+// StreamEvent is what a StreamDecoder extracted from one line of genus output.
+// The zero value means the line produced nothing.
+type StreamEvent struct {
+	Text   string // Output for the caller
+	Log    []byte // Entries appended to the session log, one per line (no trailing newline)
+	SID    ID     // Backend session this output belongs to
+	Failed bool   // The genus reported an error; the session is not adopted
+	Usage  *Usage // Token usage and cost reported for the call
+}
+
+// StreamDecoder turns lines of a genus's output into StreamEvents. A decoder
+// is created per call and may keep state between lines. An error marks the
+// line as malformed; StreamAndLog warns and skips it.
+type StreamDecoder interface {
+	Decode(line string) (StreamEvent, error)
+}
+
+// StreamFinisher is implemented by decoders that buffer output, e.g. to log
+// a streamed reply as one message. Finish is called once the stream ends
+// without a read error.
+type StreamFinisher interface {
+	Finish() StreamEvent
+}
+
+// StreamDecoderFactory creates the decoder for one call to c.
+type StreamDecoderFactory func(c *Context) StreamDecoder
+
+var streamDecoders = map[string]StreamDecoderFactory{
+	StreamFormatClaude:    func(c *Context) StreamDecoder { return claudeDecoder{} },
+	StreamFormatCodex:     func(c *Context) StreamDecoder { return &codexDecoder{c: c} },
+	StreamFormatText:      func(c *Context) StreamDecoder { return textDecoder{c: c} },
+	StreamFormatXML:       func(c *Context) StreamDecoder { return xmlDecoder{textDecoder{c: c}} },
+	StreamFormatOpenAI:    func(c *Context) StreamDecoder { return &openAIDecoder{chatReply{c: c}} },
+	StreamFormatOllama:    func(c *Context) StreamDecoder { return &ollamaDecoder{chatReply{c: c}} },
+	StreamFormatAnthropic: func(c *Context) StreamDecoder { return &anthropicDecoder{chatReply: chatReply{c: c}} },
+}
+
+// RegisterStreamDecoder makes a decoder available as a genus "format",
+// replacing any decoder registered under the same name.
+func RegisterStreamDecoder(format string, factory StreamDecoderFactory) {
+	streamDecoders[format] = factory
+}
+
+// StreamFormats lists the registered stream formats.
+func StreamFormats() []string {
+	formats := make([]string, 0, len(streamDecoders))
+	for format := range streamDecoders {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// newStreamDecoder returns the decoder for the format of c's genus. Genera
+// without a format (or unknown to config) get a decoder that picks one from
+// the first non-empty line.
+func newStreamDecoder(c *Context) (StreamDecoder, error) {
+	var format string
+	if cfg, err := LoadConfig(); err != nil {
+		Debug("LoadConfig failed in newStreamDecoder, detecting format: %v", err)
+	} else if genus, ok := cfg.GetGenus(c.GEN); ok {
+		format = genus.Format
+	}
+	if format == "" {
+		return &autoDecoder{c: c}, nil
+	}
+	factory, ok := streamDecoders[format]
+	if !ok {
+		return nil, fmt.Errorf("genus %s: unknown format %q (valid: %s)", c.GEN, format, strings.Join(StreamFormats(), ", "))
+	}
+	return factory(c), nil
+}
+
+// autoDecoder selects a decoder from the first character of the first
+// non-empty line: JSON is decoded as Claude stream-json (also accepting
+// Codex's .sessionId and .msg.message), '<' as XML, anything else as text.
+// Leading empty lines pass through.
+type autoDecoder struct {
+	c *Context
+	d StreamDecoder
+}
+
+func (a *autoDecoder) Decode(line string) (StreamEvent, error) {
+	if a.d == nil {
+		if line == "" {
+			return StreamEvent{Text: "\n"}, nil
+		}
+		format := detectFormat(line)
+		Debug("Detected output format: %s (first char: %c)", format, line[0])
+		switch format {
+		case "json":
+			a.d = claudeDecoder{generic: true}
+		case "xml":
+			a.d = streamDecoders[StreamFormatXML](a.c)
+		default:
+			a.d = streamDecoders[StreamFormatText](a.c)
+		}
+	}
+	return a.d.Decode(line)
+}
+
+// detectFormat determines the output format based on first non-empty line
+func detectFormat(firstLine string) string {
+	if len(firstLine) == 0 {
+		return "empty"
+	}
+
+	// Check first character for format detection
+	switch firstLine[0] {
+	case '{', '[':
+		return "json"
+	case '<':
+		return "xml"
+	default:
+		return "text"
+	}
+}
+
+// decodeJSONLine parses one JSON object line, ignoring empty lines.
+func decodeJSONLine(line string) (map[string]interface{}, error) {
+	if line == "" {
+		return nil, nil
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// claudeDecoder decodes Claude CLI stream-json. Lines carrying a session_id
+// are logged verbatim (the log is Claude's own format, readable by --resume);
+// assistant messages contribute their text content and establish the session.
+// A generic decoder, for JSON from genera without a format, also takes the
+// session from .sessionId and text from .msg.message, as Codex emits them.
+type claudeDecoder struct {
+	generic bool
+}
+
+func (d claudeDecoder) Decode(line string) (StreamEvent, error) {
+	data, err := decodeJSONLine(line)
+	if data == nil {
+		return StreamEvent{}, err
+	}
+
+	var ev StreamEvent
+	msgType, _ := data["type"].(string)
+	isError, _ := data["is_error"].(bool)
+
+	// Result events carry the call's usage and cost, errors included
+	if msgType == "result" {
+		ev.Usage = resultUsage(data)
+	}
+	// Mark stream as having error if we see is_error==true anywhere
+	ev.Failed = isError
+
+	// Handle explicit error types (type=="error" or type=="result" with is_error)
+	if msgType == "error" || (msgType == "result" && isError) {
+		errorMsg := jsonErrorMessage(data)
+		Warn("API error response (type=%s, is_error=%v): %s", msgType, isError, errorMsg)
+		ev.Failed = true
+
+		// Only output the error for type=="error"; for type=="result" the
+		// assistant message already displayed it. Errors are not logged: we
+		// don't want to persist failed attempts.
+		if msgType == "error" {
+			ev.Text = errorMsg + "\n"
+		}
+		return ev, nil
+	}
+
+	// Write JSON lines with session_id to log immediately (errors are logged
+	// too, but the SID won't be adopted from a failed stream)
+	sidKey := "session_id"
+	if _, ok := data[sidKey]; !ok && d.generic {
+		sidKey = "sessionId"
+	}
+	if _, ok := data[sidKey]; ok {
+		ev.Log = []byte(line)
+	}
+
+	// Only assistant messages indicate an established session
+	if sid, _ := data[sidKey].(string); msgType == "assistant" && sid != "" {
+		ev.SID = ID(sid)
+	}
+	ev.Text = contentText(data["message"])
+	if _, ok := data["message"].(map[string]interface{}); d.generic && !ok && msgType != "assistant" {
+		if msg, ok := data["msg"].(map[string]interface{}); ok {
+			ev.Text, _ = msg["message"].(string)
+		}
+	}
+	return ev, nil
+}
+
+// contentText joins the text items of a message's content array
+// (.message.content[].text).
+func contentText(message interface{}) string {
+	msg, ok := message.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	content, ok := msg["content"].([]interface{})
+	if !ok {
+		return ""
+	}
+	var text string
+	for _, item := range content {
+		if itemMap, ok := item.(map[string]interface{}); ok {
+			if t, ok := itemMap["text"].(string); ok {
+				text += t
+			}
+		}
+	}
+	return text
+}
+
+// jsonErrorMessage extracts the message of a JSON error event from
+// .error.message, .result or .message.
+func jsonErrorMessage(data map[string]interface{}) string {
+	if err, ok := data["error"].(map[string]interface{}); ok {
+		if msg, ok := err["message"].(string); ok && msg != "" {
+			return msg
+		}
+	}
+	if msg, ok := data["result"].(string); ok && msg != "" {
+		return msg
+	}
+	if msg, ok := data["message"].(string); ok && msg != "" {
+		return msg
+	}
+	return "Unknown API error"
+}
+
+// textDecoder passes plain text through, logging each non-empty line as an
+// assistant message.
+type textDecoder struct {
+	c *Context
+}
+
+func (d textDecoder) Decode(line string) (StreamEvent, error) {
+	ev := StreamEvent{Text: line + "\n"}
+	if line != "" {
+		ev.Log = logMessage(d.c.SID, "assistant", line)
+	}
+	return ev, nil
+}
+
+// logMessage marshals a Message in session sid for the session log.
+func logMessage(sid ID, from, body string) []byte {
+	data, err := json.Marshal(Message{
+		SessionID: sid,
+		At:        time.Now(),
+		From:      from,
+		Body:      body,
+	})
+	if err != nil {
+		Warn("Failed to marshal message: %v", err)
+		return nil
+	}
+	return data
+}
+
+// xmlTagPattern matches XML tags, comments, processing instructions and
+// declarations.
+var xmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// xmlDecoder decodes XML-tagged text output (e.g. <response>...</response>)
+// by stripping markup and unescaping entities. Lines holding only markup
+// produce nothing.
+type xmlDecoder struct {
+	textDecoder
+}
+
+func (d xmlDecoder) Decode(line string) (StreamEvent, error) {
+	text := html.UnescapeString(xmlTagPattern.ReplaceAllString(line, ""))
+	if strings.TrimSpace(text) == "" && line != "" {
+		return StreamEvent{}, nil
+	}
+	return d.textDecoder.Decode(text)
+}
@@ -0,0 +1,153 @@
+package aimux
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestStreamDecoders verifies each built-in decoder's output, log and session handling
+func TestStreamDecoders(t *testing.T) {
+	c := &Context{SID: "12345678-1234-4123-8234-123456789abc"}
+	sid := "87654321-1234-4123-8234-123456789abc"
+
+	tests := []struct {
+		name    string
+		format  string
+		line    string
+		text    string
+		logged  bool
+		sid     ID
+		failed  bool
+		wantErr bool
+	}{
+		{"claude assistant", StreamFormatClaude, `{"type":"assistant","session_id":"` + sid + `","message":{"content":[{"type":"text","text":"hi "},{"type":"text","text":"there"}]}}`, "hi there", true, ID(sid), false, false},
+		{"claude init", StreamFormatClaude, `{"type":"system","subtype":"init","session_id":"` + sid + `"}`, "", true, "", false, false},
+		{"claude error", StreamFormatClaude, `{"type":"error","error":{"message":"overloaded"}}`, "overloaded\n", false, "", true, false},
+		{"claude failed result", StreamFormatClaude, `{"type":"result","is_error":true,"result":"boom","session_id":"` + sid + `"}`, "", false, "", true, false},
+		{"claude malformed", StreamFormatClaude, `{"type":`, "", false, "", false, true},
+		{"claude empty", StreamFormatClaude, ``, "", false, "", false, false},
+		{"codex message", StreamFormatCodex, `{"id":"1","msg":{"type":"agent_message","message":"done"}}`, "done\n", true, "", false, false},
+		{"codex error", StreamFormatCodex, `{"id":"1","msg":{"type":"error","message":"bad model"}}`, "bad model\n", false, "", true, false},
+		{"text", StreamFormatText, `plain output`, "plain output\n", true, "", false, false},
+		{"text empty", StreamFormatText, ``, "\n", false, "", false, false},
+		{"xml", StreamFormatXML, `<answer>a &lt; b</answer>`, "a < b\n", true, "", false, false},
+		{"xml markup only", StreamFormatXML, `<?xml version="1.0"?>`, "", false, "", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := streamDecoders[tt.format](c).Decode(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ev.Text != tt.text {
+				t.Errorf("Decode() text = %q, want %q", ev.Text, tt.text)
+			}
+			if (ev.Log != nil) != tt.logged {
+				t.Errorf("Decode() log = %s, want logged %v", ev.Log, tt.logged)
+			}
+			if ev.SID != tt.sid {
+				t.Errorf("Decode() sid = %q, want %q", ev.SID, tt.sid)
+			}
+			if ev.Failed != tt.failed {
+				t.Errorf("Decode() failed = %v, want %v", ev.Failed, tt.failed)
+			}
+		})
+	}
+}
+
+// TestStreamAndLogFormat verifies StreamAndLog uses the genus's configured format
+func TestStreamAndLogFormat(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	writeTestLog(t, filepath.Join(tmpDir, ".aimux", "config.json"), `{"genera": {
+		"echo": {"name": "echo", "exe": ["echo"], "format": "xml", "personas": {"": {}}},
+		"auto": {"name": "auto", "exe": ["echo"], "personas": {"": {}}},
+		"shout": {"name": "shout", "exe": ["echo"], "format": "shout", "personas": {"": {}}},
+		"typo": {"name": "typo", "exe": ["echo"], "format": "jsonl", "personas": {"": {}}}
+	}}`)
+	RegisterStreamDecoder("shout", func(c *Context) StreamDecoder { return shoutDecoder{} })
+	defer delete(streamDecoders, "shout")
+
+	tests := []struct {
+		gen     string
+		stream  string
+		want    string
+		wantErr bool
+	}{
+		{"echo", "<response>\n<text>It works</text>\n</response>\n", "It works\n", false},
+		{"auto", `{"type":"assistant","message":{"content":[{"type":"text","text":"sniffed\n"}]}}` + "\n", "sniffed\n", false},
+		{"auto", "\nplain\n", "\nplain\n", false},
+		{"bash", "<b>not xml</b>\n", "<b>not xml</b>\n", false},
+		{"shout", "quiet\n", "QUIET", false},
+		{"typo", "{}\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.gen, func(t *testing.T) {
+			ctx, err := InitContext(tt.gen, "")
+			if err != nil {
+				t.Fatalf("InitContext() error = %v", err)
+			}
+			var out bytes.Buffer
+			_, err = StreamAndLog(ctx, strings.NewReader(tt.stream), &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("StreamAndLog() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("StreamAndLog() output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStreamAndLogAutoSessionID verifies JSON from a genus without a format
+// keeps its Codex-style .sessionId session and .msg.message text
+func TestStreamAndLogAutoSessionID(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	writeTestLog(t, filepath.Join(tmpDir, ".aimux", "config.json"), `{"genera": {
+		"auto": {"name": "auto", "exe": ["echo"], "personas": {"": {}}}
+	}}`)
+
+	ctx, err := InitContext("auto", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+	sid := "87654321-1234-4123-8234-123456789abc"
+	stream := `{"sessionId":"` + sid + `","msg":{"type":"agent_message","message":"thinking\n"}}` + "\n" +
+		`{"type":"assistant","sessionId":"` + sid + `","message":{"content":[{"type":"text","text":"done\n"}]}}` + "\n"
+	var out bytes.Buffer
+	if _, err := StreamAndLog(ctx, strings.NewReader(stream), &out); err != nil {
+		t.Fatalf("StreamAndLog() error = %v", err)
+	}
+	if got := out.String(); got != "thinking\ndone\n" {
+		t.Errorf("StreamAndLog() output = %q", got)
+	}
+	if ctx.SID != ID(sid) {
+		t.Errorf("session = %s, want %s", ctx.SID, sid)
+	}
+	log3, err := Log3(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := lastSessionID(log3); err != nil || got != ID(sid) {
+		t.Errorf("lastSessionID() = %s, %v, want %s", got, err, sid)
+	}
+}
+
+// shoutDecoder is a custom decoder registered by TestStreamAndLogFormat
+type shoutDecoder struct{}
+
+func (shoutDecoder) Decode(line string) (StreamEvent, error) {
+	return StreamEvent{Text: strings.ToUpper(line)}, nil
+}
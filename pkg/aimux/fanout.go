@@ -0,0 +1,70 @@
+package aimux
+
+// fanout.go - Calling several personas at once with the same prompt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// CallResult is the outcome of one call of a Fanout or Chain.
+type CallResult struct {
+	Context *Context
+	Record  *CallRecord // nil if the call could not start
+	Output  []byte      // What the genus output
+	Err     error       // The error that ended the call, if any
+}
+
+// Fanout makes the same call (cmdArgs and stdin) to each context with RunCall,
+// running at most parallel calls at once (all at once if parallel <= 0).
+// Each call is validated, logged and recorded on its own, like a direct call,
+// so a blocked or failed call does not stop the others. Stdin is read once
+// and given to every call. Results are in the order of contexts.
+func Fanout(ctx context.Context, contexts []*Context, cmdArgs string, stdin io.Reader, parallel int) ([]CallResult, error) {
+	return fanout(ctx, contexts, ID(os.Getenv(callEnvVar)), cmdArgs, stdin, parallel)
+}
+
+// fanout is Fanout with the parent of its calls given (see Serve).
+func fanout(ctx context.Context, contexts []*Context, parent ID, cmdArgs string, stdin io.Reader, parallel int) ([]CallResult, error) {
+	var input []byte
+	if stdin != nil {
+		var err error
+		if input, err = io.ReadAll(stdin); err != nil {
+			return nil, fmt.Errorf("read stdin: %w", err)
+		}
+	}
+	if parallel <= 0 || parallel > len(contexts) {
+		parallel = len(contexts)
+	}
+
+	results := make([]CallResult, len(contexts))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, c := range contexts {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, c *Context) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			var callStdin io.Reader
+			if stdin != nil {
+				callStdin = bytes.NewReader(input)
+			}
+			var out bytes.Buffer
+			id, err := NewID()
+			if err != nil {
+				results[i] = CallResult{Context: c, Err: err}
+				return
+			}
+			rec, err := runCall(ctx, c, id, parent, cmdArgs, callStdin, &out)
+			results[i] = CallResult{Context: c, Record: rec, Output: out.Bytes(), Err: err}
+		}(i, c)
+	}
+	wg.Wait()
+	return results, nil
+}
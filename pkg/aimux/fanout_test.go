@@ -0,0 +1,103 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFanout(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	t.Setenv("AICALL", "")
+
+	cid, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	join := func(mods ...string) []*Context {
+		var contexts []*Context
+		for _, mod := range mods {
+			c, err := JoinContext(cid, "bash", mod)
+			if err != nil {
+				t.Fatalf("JoinContext(%q) error = %v", mod, err)
+			}
+			contexts = append(contexts, c)
+		}
+		return contexts
+	}
+
+	// Calls that overlap report it (busy is a mutex only one call can hold)
+	busy := filepath.Join(tmpDir, "busy")
+	cmd := `mkdir ` + busy + ` 2>/dev/null || echo overlap; sleep 0.05; rmdir ` + busy + ` 2>/dev/null; echo "$AIMOD: $(cat)"`
+
+	// One at a time, into a conversation that does not exist yet
+	contexts := join("architect", "engineer", "")
+	results, err := Fanout(context.Background(), contexts, cmd, strings.NewReader("shared"), 1)
+	if err != nil {
+		t.Fatalf("Fanout() error = %v", err)
+	}
+	for i, want := range []string{"architect: shared\n", "engineer: shared\n", ": shared\n"} {
+		r := results[i]
+		if r.Err != nil || string(r.Output) != want || r.Context != contexts[i] || r.Record == nil {
+			t.Errorf("result %d = %q, %v, want %q", i, r.Output, r.Err, want)
+		}
+	}
+
+	// Each answer is logged in its persona's session
+	for _, mod := range []string{"architect", "engineer", ""} {
+		msgs, err := Transcript(cid, TranscriptFilter{MOD: modFilter(mod)})
+		if err != nil || len(msgs) != 2 || msgs[1].Body != mod+": shared" {
+			t.Errorf("Transcript(%q) = %+v, %v", mod, msgs, err)
+		}
+	}
+
+	// All at once: a blocked call does not stop the others
+	contexts = join("architect", "engineer")
+	contexts[0].LVL = 3
+	results, err = Fanout(context.Background(), contexts, "echo $AIMOD", nil, 0)
+	if err != nil {
+		t.Fatalf("Fanout() error = %v", err)
+	}
+	var blockErr *BlockingError
+	if !errors.As(results[0].Err, &blockErr) || !results[0].Record.Blocked {
+		t.Errorf("result 0 error = %v, want blocked", results[0].Err)
+	}
+	if results[1].Err != nil || string(results[1].Output) != "engineer\n" {
+		t.Errorf("result 1 = %q, %v", results[1].Output, results[1].Err)
+	}
+	records, err := LoadCalls(cid)
+	if err != nil || len(records) != 5 {
+		t.Errorf("LoadCalls() = %d records, %v, want 5", len(records), err)
+	}
+
+	// Two at a time: a third call finds both slots taken
+	slot := filepath.Join(tmpDir, "slot")
+	cmd = `if mkdir ` + slot + `1 2>/dev/null; then s=1; elif mkdir ` + slot + `2 2>/dev/null; then s=2; else echo overlap; fi; ` +
+		`sleep 0.05; rmdir ` + slot + `$s 2>/dev/null; echo $AIMOD`
+	contexts = join("architect", "engineer", "customer", "reviewer")
+	results, err = Fanout(context.Background(), contexts, cmd, nil, 2)
+	if err != nil {
+		t.Fatalf("Fanout() error = %v", err)
+	}
+	for i, r := range results {
+		if want := contexts[i].MOD + "\n"; r.Err != nil || string(r.Output) != want {
+			t.Errorf("result %d = %q, %v, want %q", i, r.Output, r.Err, want)
+		}
+	}
+}
+
+// modFilter returns the TranscriptFilter MOD selecting persona mod's session.
+func modFilter(mod string) string {
+	if mod == "" {
+		return emptyModPlaceholder
+	}
+	return mod
+}
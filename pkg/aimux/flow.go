@@ -95,12 +95,19 @@ type LazyCommandStream struct {
 	ctx     context.Context
 	cancel  context.CancelFunc
 	timeout time.Duration
+	argv    []string // Command line with prompts redacted (see Argv)
 
 	once   sync.Once
 	stream *CommandStream
 	err    error
 }
 
+// Argv returns the genus command line with the system prompt and user
+// prompt redacted, for audit records.
+func (lcs *LazyCommandStream) Argv() []string {
+	return lcs.argv
+}
+
 func (lcs *LazyCommandStream) Read(p []byte) (n int, err error) {
 	lcs.once.Do(func() {
 		lcs.stream, lcs.err = lcs.start()
@@ -252,6 +259,27 @@ func ResumeContext(cid ID, gen, model string) (*Context, error) {
 		return nil, err
 	}
 
+	// Check if conversation exists by verifying its directory
+	dir0, err := Dir0(&Context{CID: cid})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir0); os.IsNotExist(err) {
+		return nil, fmt.Errorf("conversation does not exist")
+	}
+	return JoinContext(cid, gen, model)
+}
+
+// JoinContext loads the context of genus gen and persona model in
+// conversation cid, which unlike with ResumeContext need not exist yet (its
+// directories are created when a call first answers). A genus or persona
+// joining a conversation (e.g. a nested partner call inheriting AICID) has no
+// context.json yet and starts from fresh state.
+func JoinContext(cid ID, gen, model string) (*Context, error) {
+	if err := ValidateContextParams(string(cid), gen, model); err != nil {
+		return nil, err
+	}
+
 	ctx := &Context{
 		CID: cid,
 		SID: cid,
@@ -288,10 +316,14 @@ func ResumeContext(cid ID, gen, model string) (*Context, error) {
 	}
 	ctx.SID = sid
 
-	// Check if conversation exists by verifying context.json file
 	ctxPath := filepath.Join(ctx.DIR, contextFileName)
-	if _, err := os.Stat(ctxPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("conversation does not exist")
+	if !fileExists(ctxPath) && ctx.SID == ctx.CID {
+		// Joining without history: the CID-named backend session may already
+		// belong to another genus or persona, so start a session of our own
+		if ctx.SID, err = NewID(); err != nil {
+			return nil, err
+		}
+		Debug("Joining conversation %s with new SID %s", ctx.CID, ctx.SID)
 	}
 
 	if data, err := os.ReadFile(ctxPath); err == nil {
@@ -334,6 +366,156 @@ func Branch(ctx *Context) error {
 	return nil
 }
 
+// Rewind reconstructs session state as of cutoff. It selects the SID that was
+// active at that time and marks the context (AIRWD) so the next call forks the
+// backend session from there via GenusArgs.Branch, and so referenced context
+// only includes messages from before the cutoff.
+func Rewind(c *Context, cutoff time.Time) error {
+	sid, err := SessionAt(c, cutoff)
+	if err != nil {
+		return err
+	}
+	Debug("Rewind to %s selected SID %s (was %s)", cutoff.Format(time.RFC3339), sid, c.SID)
+	c.SID = sid
+	c.ENV["AIRWD"] = cutoff.Format(time.RFC3339)
+	c.ENV["AITEMPORAL"] = "query"
+	return nil
+}
+
+// SessionAt returns the SID that was active at cutoff, i.e. the session of the
+// last log entry written at or before cutoff. Checks log2, then log1 (if
+// undifferentiated), matching DetermineSessionID.
+func SessionAt(c *Context, cutoff time.Time) (ID, error) {
+	sid, _, err := sessionAt(c, cutoff)
+	return sid, err
+}
+
+// sessionAt is SessionAt, also reporting whether the session continued
+// after cutoff.
+func sessionAt(c *Context, cutoff time.Time) (ID, bool, error) {
+	log2, err := Log2(c)
+	if err != nil {
+		return "", false, err
+	}
+	paths := []string{log2}
+	if c.MOD == "" {
+		log1, err := Log1(c)
+		if err != nil {
+			return "", false, err
+		}
+		paths = append(paths, log1)
+	}
+
+	for _, path := range paths {
+		sid, later, err := sessionAtInLog(path, cutoff)
+		if err != nil {
+			return "", false, err
+		}
+		if sid != "" {
+			return sid, later, nil
+		}
+	}
+	return "", false, fmt.Errorf("no session activity at or before %s", cutoff.Format(time.RFC3339))
+}
+
+// sessionAtInLog scans a JSONL log for the last session ID recorded at or
+// before cutoff, and reports whether that session has entries after cutoff
+// too. Lines without a timestamp (e.g. raw stream-json) inherit the
+// timestamp of the preceding line. Returns empty ID if none qualify.
+func sessionAtInLog(path string, cutoff time.Time) (ID, bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+
+	var sid ID
+	var lastAt time.Time
+	later := make(map[ID]bool)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineLength)
+	for scanner.Scan() {
+		var data map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+			continue
+		}
+		if at, ok := lineTime(data); ok {
+			lastAt = at
+		}
+		if lastAt.IsZero() {
+			continue
+		}
+		s, _ := data["session_id"].(string)
+		if s == "" {
+			s, _ = data["sessionId"].(string)
+		}
+		if !isValidUUID(s) {
+			continue
+		}
+		if lastAt.After(cutoff) {
+			later[ID(NormalizeUUID(s))] = true
+		} else {
+			sid = ID(NormalizeUUID(s))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", false, err
+	}
+	return sid, later[sid], nil
+}
+
+// lineTime extracts the timestamp of a log line from its "at" (Message) or
+// "timestamp" (stream-json) field.
+func lineTime(data map[string]interface{}) (time.Time, bool) {
+	for _, key := range []string{"at", "timestamp"} {
+		if s, ok := data[key].(string); ok && s != "" {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil && !t.IsZero() {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// modelPersonaFor returns the persona whose genus vars select c's model: c.MOD
+// unless HUD parsing overrode the model (ENV["AIMODEL"]).
+func modelPersonaFor(c *Context) string {
+	if override := c.ENV["AIMODEL"]; override != "" {
+		Debug("Model override active: using %s instead of %s for model selection", override, c.MOD)
+		return override
+	}
+	return c.MOD
+}
+
+// fallbackModel returns the model of c's persona and the model2 to retry with
+// if it fails before answering. to is empty when there is no fallback: the
+// persona is not configured in the genus or has no distinct model2, or the
+// genus falls back natively (its model args pass {{model2}}, as claude's
+// --fallback-model does).
+func fallbackModel(c *Context) (from, to string, err error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return "", "", fmt.Errorf("load config: %w", err)
+	}
+	genus, ok := cfg.GetGenus(c.GEN)
+	if !ok {
+		return "", "", nil
+	}
+	vars, ok := genus.Personas[modelPersonaFor(c)]
+	if !ok || vars["model2"] == "" || vars["model2"] == vars["model"] {
+		return "", "", nil
+	}
+	for _, arg := range genus.Args.Model {
+		if strings.Contains(arg, "{{model2}}") {
+			return "", "", nil
+		}
+	}
+	return vars["model"], vars["model2"], nil
+}
+
 // CallGenus invokes the genus CLI with config-driven arg construction.
 // stdin is passed as io.Reader to allow streaming - genus controls when/how to consume it.
 //
@@ -348,11 +530,30 @@ func CallGenus(ctx context.Context, c *Context, cmdArgs string, stdin io.Reader)
 	if err != nil {
 		return nil, fmt.Errorf("load config: %w", err)
 	}
+	if err := cfg.CheckBudget(c); err != nil {
+		return nil, err
+	}
 	genus, ok := cfg.GetGenus(c.GEN)
 	if !ok {
 		return nil, fmt.Errorf("unknown genus: %s", c.GEN)
 	}
 
+	personaVars := cfg.GetGenusPersonaVars(c.GEN, modelPersonaFor(c))
+	if fallback := c.ENV[fallbackEnvKey]; fallback != "" {
+		// Retrying with model2 (see runGenus): model2 has nothing to fall back to
+		vars := PersonaVars{}
+		for k, v := range personaVars {
+			vars[k] = v
+		}
+		vars["model"], vars["model2"] = fallback, ""
+		personaVars = vars
+		Debug("Falling back to model %s", fallback)
+	}
+
+	if isHTTPGenus(genus) {
+		return callHTTPGenus(ctx, c, genus, personaVars, cmdArgs, stdin)
+	}
+
 	if len(genus.Exe) == 0 {
 		return nil, fmt.Errorf("genus %s has no exe configured", c.GEN)
 	}
@@ -360,16 +561,6 @@ func CallGenus(ctx context.Context, c *Context, cmdArgs string, stdin io.Reader)
 		return nil, err
 	}
 
-	// Check for model override from HUD parsing
-	// c.MOD provides behavioral persona, ENV["AIMODEL"] can override model selection
-	modelPersona := c.MOD
-	if override := c.ENV["AIMODEL"]; override != "" {
-		modelPersona = override
-		Debug("Model override active: using %s instead of %s for model selection", override, c.MOD)
-	}
-
-	personaVars := cfg.GetGenusPersonaVars(c.GEN, modelPersona)
-
 	// For bash genus: if both cmdArgs and stdin provided, use bash -c to execute command
 	// This allows: echo "input" | ./aimux -gen=bash "cat" to work properly
 	var useBashC bool
@@ -410,17 +601,7 @@ func CallGenus(ctx context.Context, c *Context, cmdArgs string, stdin io.Reader)
 		args = append(args, genus.Args.Safety...)
 	}
 
-	// Use custom system prompt if provided, otherwise generate protocol prompt
-	var systemPrompt string
-	if customPrompt := c.ENV["AISYS"]; customPrompt != "" {
-		systemPrompt = customPrompt
-	} else {
-		// Generate system prompt with incremented depth for subprocess perspective
-		// Shell increments AILVL before generating system prompt
-		promptCtx := *c
-		promptCtx.LVL++
-		systemPrompt = Sys(&promptCtx)
-	}
+	systemPrompt := systemPromptFor(c)
 
 	// Handle system prompt injection and stdin
 	var stdinContent io.Reader
@@ -473,14 +654,7 @@ func CallGenus(ctx context.Context, c *Context, cmdArgs string, stdin io.Reader)
 		}
 	}
 
-	// Create context with timeout (default 30 minutes, configurable)
-	timeout := 30 * time.Minute
-	if c.ENV["AITIMEOUT"] != "" {
-		if t, err := time.ParseDuration(c.ENV["AITIMEOUT"]); err == nil && t > 0 {
-			timeout = t
-		}
-	}
-
+	timeout := callTimeout(c)
 	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
 
 	// Create the command with timeout context
@@ -489,13 +663,19 @@ func CallGenus(ctx context.Context, c *Context, cmdArgs string, stdin io.Reader)
 	Debug("Executing: %s %v", genus.Exe[0], fullArgs)
 	cmd := exec.CommandContext(cmdCtx, genus.Exe[0], fullArgs...)
 
-	// Set process group for proper cleanup (Unix only)
+	// Set process group for proper cleanup (Unix only). On timeout or
+	// cancellation, kill the whole group: a grandchild holding stdout open
+	// would otherwise keep the call running.
 	if runtime.GOOS != "windows" {
 		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Cancel = func() error {
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
 	}
 
 	// Pass environment variables to subprocess
-	cmd.Env = os.Environ()
+	cmd.Env = callEnviron(c)
+	cmd.Dir = c.workDir
 
 	// Export aimux-specific variables for subprocess (matching shell script)
 	// AITAG: who the subprocess is (current tag)
@@ -509,6 +689,10 @@ func CallGenus(ctx context.Context, c *Context, cmdArgs string, stdin io.Reader)
 	cmd.Env = append(cmd.Env, fmt.Sprintf("AITAG=%s", c.TAG))
 	cmd.Env = append(cmd.Env, fmt.Sprintf("AITOP=%s", c.TOP))
 	cmd.Env = append(cmd.Env, fmt.Sprintf("AILVL=%d", c.LVL+1))
+	// AICALL: this call's ID (see RunCall), recorded as the parent of nested calls
+	if call := c.ENV[callEnvKey]; call != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", callEnvVar, call))
+	}
 
 	if c.WTF {
 		cmd.Env = append(cmd.Env, "AIWTF=1")
@@ -521,40 +705,92 @@ func CallGenus(ctx context.Context, c *Context, cmdArgs string, stdin io.Reader)
 		ctx:     cmdCtx,
 		cancel:  cancel,
 		timeout: timeout,
+		argv:    redactArgv(append([]string{genus.Exe[0]}, fullArgs...), systemPrompt, cmdArgs),
 	}, nil
 }
 
-// buildSessionFlags constructs session management flags based on log file state.
-// Returns the flags and a boolean indicating if we're starting a NEW session (true)
-// vs resuming/branching (false). This helps StreamAndLog know whether to accept
-// session_id from subprocess output.
-func buildSessionFlags(c *Context, genus GenusConfig, personaVars PersonaVars) ([]string, bool, error) {
+// systemPromptFor returns the custom system prompt (AISYS) if provided,
+// otherwise the generated partner protocol prompt.
+func systemPromptFor(c *Context) string {
+	if customPrompt := c.ENV["AISYS"]; customPrompt != "" {
+		return customPrompt
+	}
+	// Generate system prompt with incremented depth for subprocess perspective
+	// Shell increments AILVL before generating system prompt
+	promptCtx := *c
+	promptCtx.LVL++
+	return Sys(&promptCtx)
+}
+
+// callTimeout returns the call timeout: 30 minutes, or AITIMEOUT if set.
+func callTimeout(c *Context) time.Duration {
+	timeout := 30 * time.Minute
+	if c.ENV["AITIMEOUT"] != "" {
+		if t, err := time.ParseDuration(c.ENV["AITIMEOUT"]); err == nil && t > 0 {
+			timeout = t
+		}
+	}
+	return timeout
+}
+
+// redactArgv returns a copy of argv with every occurrence of the system
+// prompt and user prompt replaced by a placeholder.
+func redactArgv(argv []string, systemPrompt, prompt string) []string {
+	redacted := make([]string, len(argv))
+	for i, arg := range argv {
+		if systemPrompt != "" {
+			arg = strings.ReplaceAll(arg, systemPrompt, "<system-prompt>")
+		}
+		if prompt != "" {
+			arg = strings.ReplaceAll(arg, prompt, "<prompt>")
+		}
+		redacted[i] = arg
+	}
+	return redacted
+}
+
+// sessionMode is how a call continues a genus session.
+type sessionMode int
+
+const (
+	sessionNew    sessionMode = iota // Start session c.SID
+	sessionResume                    // Continue session c.SID
+	sessionBranch                    // Fork session c.SID into a new session
+)
+
+// chooseSession decides from log file state whether a call starts, resumes
+// or branches a session. canBranch reports whether the genus can fork
+// sessions; without it, branching falls back to resuming. Starting a session
+// in a persona log that already exists gives c a new SID (not saved yet).
+func chooseSession(c *Context, canBranch bool) (sessionMode, error) {
 	log2, err := Log2(c)
 	if err != nil {
-		return nil, false, err
+		return sessionNew, err
 	}
 	log1, err := Log1(c)
 	if err != nil {
-		return nil, false, err
+		return sessionNew, err
 	}
 
-	sidVars := map[string]string{"sid": string(c.SID)}
-	for k, v := range personaVars {
-		sidVars[k] = v
-	}
+	// Temporal rewind always forks from the SID selected by Rewind,
+	// leaving the original session intact
+	rewind := c.ENV["AIRWD"] != "" && canBranch
 
 	// Check if log2 has established session (assistant responses present)
 	if hasEstablishedSession(log2) {
-		return RenderFlags(genus.Args.Resume, sidVars), false, nil
+		if rewind {
+			return sessionBranch, nil
+		}
+		return sessionResume, nil
 	}
 
 	// Check if log1 has established session (assistant responses present)
 	if hasEstablishedSession(log1) {
 		// Check if log2 exists (even if empty) - indicates we're branching
-		if fileExists(log2) && len(genus.Args.Branch) > 0 {
-			return RenderFlags(genus.Args.Branch, sidVars), false, nil
+		if (rewind || fileExists(log2)) && canBranch {
+			return sessionBranch, nil
 		}
-		return RenderFlags(genus.Args.Resume, sidVars), false, nil
+		return sessionResume, nil
 	}
 
 	// No existing session - need to start new one
@@ -567,45 +803,120 @@ func buildSessionFlags(c *Context, genus GenusConfig, personaVars PersonaVars) (
 	if fileExists(log2) && c.CID != c.SID {
 		newSID, err := NewID()
 		if err != nil {
-			return nil, false, err
+			return sessionNew, err
 		}
 		// Update SID in context for CLI arg, but don't save yet
 		// StreamAndLog will save it only if the call succeeds
 		c.SID = newSID
-		sidVars["sid"] = string(newSID)
 		Debug("Generated new SID for branching: %s (will save if call succeeds)", newSID)
 	}
+	return sessionNew, nil
+}
+
+// buildSessionFlags constructs session management flags based on log file state.
+// Returns the flags and a boolean indicating if we're starting a NEW session (true)
+// vs resuming/branching (false). This helps StreamAndLog know whether to accept
+// session_id from subprocess output.
+func buildSessionFlags(c *Context, genus GenusConfig, personaVars PersonaVars) ([]string, bool, error) {
+	mode, err := chooseSession(c, len(genus.Args.Branch) > 0)
+	if err != nil {
+		return nil, false, err
+	}
 
+	sidVars := map[string]string{"sid": string(c.SID)}
+	for k, v := range personaVars {
+		sidVars[k] = v
+	}
+
+	switch mode {
+	case sessionResume:
+		if err := checkRewind(c); err != nil {
+			return nil, false, err
+		}
+		return RenderFlags(genus.Args.Resume, sidVars), false, nil
+	case sessionBranch:
+		if err := checkRewind(c); err != nil {
+			return nil, false, err
+		}
+		return RenderFlags(genus.Args.Branch, sidVars), false, nil
+	}
 	// Fresh start - we're passing explicit --session-id, so don't accept session_id from output
 	return RenderFlags(genus.Args.New, sidVars), true, nil
 }
 
-// detectFormat determines the output format based on first non-empty line
-func detectFormat(firstLine string) string {
-	if len(firstLine) == 0 {
-		return "empty"
+// checkRewind refuses a rewound call (see Rewind) that continues a session
+// which went on after the cutoff: a CLI genus resumes or forks the whole
+// backend session, so the turns after the cutoff would be kept and the rewind
+// would not take effect. Chat API genera rebuild the history instead.
+func checkRewind(c *Context) error {
+	if c.ENV["AIRWD"] == "" {
+		return nil
 	}
-
-	// Check first character for format detection
-	switch firstLine[0] {
-	case '{', '[':
-		return "json"
-	case '<':
-		return "xml"
-	default:
-		return "text"
+	cutoff, err := time.Parse(time.RFC3339, c.ENV["AIRWD"])
+	if err != nil {
+		return fmt.Errorf("invalid rewind cutoff %q: %w", c.ENV["AIRWD"], err)
 	}
+	_, later, err := sessionAt(c, cutoff)
+	if err != nil {
+		return err
+	}
+	if later {
+		return fmt.Errorf("cannot rewind to %s: session %s went on after it, and genus %s can only resume or fork the whole session", cutoff.Format(time.RFC3339), c.SID, c.GEN)
+	}
+	return nil
 }
 
-// StreamAndLog reads output from genus command, detects format, and handles accordingly.
-// Supports JSON (Claude CLI), plain text (cat/echo), and extensible for XML/other formats.
+// StreamResult summarizes a stream handled by StreamAndLog.
+type StreamResult struct {
+	OutputBytes int64  // Bytes written to the output writer
+	Truncated   bool   // Output stopped at MaxOutputSize
+	Usage       *Usage // Token usage and cost, if the genus reported it
+	Failed      bool   // The genus reported an error in its output
+	// EarlyFailure is the error the genus reported before any output, when
+	// held back for a fallback (see streamAndLog)
+	EarlyFailure string
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// StreamAndLog reads output from genus command and handles it with the
+// StreamDecoder for the genus's configured format (see decode.go), writing
+// decoded text to w and decoded log entries to the session log.
 // (Equivalent to ai:claude:cat and ai::cat pipelines in shell version)
 //
 // IMPORTANT: Delays filesystem operations (creating directories, opening log files) until
 // after the first line is read. This prevents creating artifacts if the command fails early.
-func StreamAndLog(c *Context, r io.Reader, w io.Writer) error {
-	// Use buffered writer for better performance
-	bufWriter := bufio.NewWriter(w)
+//
+// The returned StreamResult is non-nil even when an error is returned.
+func StreamAndLog(c *Context, r io.Reader, w io.Writer) (*StreamResult, error) {
+	return streamAndLog(c, r, w, false)
+}
+
+// streamAndLog is StreamAndLog. With holdEarlyFailure, an error event before
+// any output stops the stream without being output or logged, and is
+// returned as the result's EarlyFailure so the call can fall back.
+func streamAndLog(c *Context, r io.Reader, w io.Writer, holdEarlyFailure bool) (*StreamResult, error) {
+	result := &StreamResult{}
+
+	decoder, err := newStreamDecoder(c)
+	if err != nil {
+		return result, err
+	}
+
+	// Use buffered writer for better performance, counting what reaches w
+	counter := &countingWriter{w: w}
+	defer func() { result.OutputBytes = counter.n }()
+	bufWriter := bufio.NewWriter(counter)
 	defer bufWriter.Flush()
 
 	// Set max line length to prevent OOM
@@ -616,37 +927,94 @@ func StreamAndLog(c *Context, r io.Reader, w io.Writer) error {
 	totalOutput := 0
 	lineNumber := 0
 
-	// Detect format from first non-empty line
-	var format string
-
 	// Track if we've seen an error in this stream (prevents SID updates)
 	streamHasError := false
 
-	// Track new SID from assistant messages (only apply if no errors)
+	// Track new SID from the stream (only apply if no errors)
 	var pendingSID ID
 	sidSaved := false  // Track if we've already saved the pending SID
 	sidLogged := false // Track if we've already logged the pending SID
 
-	// Delay log file creation until after first line
-	var logFile *os.File
-	defer func() {
-		if logFile != nil {
-			logFile.Close()
+	// Delay log file creation until after first non-empty line. Entries are
+	// appended under the log's lock one at a time, so they never interleave
+	// with concurrent appends.
+	var logPath string
+
+	// apply handles a decoded event: records usage and errors, logs, tracks
+	// the session and writes output
+	apply := func(ev StreamEvent) error {
+		if ev.Failed && holdEarlyFailure && totalOutput == 0 {
+			result.EarlyFailure = strings.TrimSpace(ev.Text)
+			if result.EarlyFailure == "" {
+				result.EarlyFailure = errGenusFailed.Error()
+			}
+			return errEarlyFailure
+		}
+		if ev.Usage != nil {
+			result.Usage = ev.Usage
+		}
+		if ev.Failed {
+			streamHasError = true
+			result.Failed = true
+			// Clear pending SID immediately - don't save error sessions
+			if pendingSID != "" {
+				Debug("Discarding pending SID %s due to error in stream", pendingSID)
+				pendingSID = ""
+			}
 		}
-	}()
+
+		if ev.Log != nil && logPath != "" {
+			if err := appendLocked(logPath, append(ev.Log, '\n')); err != nil {
+				Warn("Failed to write to log file: %v", err)
+				// Continue processing even if logging fails
+			}
+		}
+
+		// Collect session ID (defer update until stream end)
+		if ev.SID != "" && pendingSID == "" {
+			pendingSID = ev.SID
+			// Only log first time we see a session ID (signal change)
+			if !sidLogged {
+				sidLogged = true
+				Debug("Session established: %s", pendingSID)
+			}
+		}
+
+		// Save pending SID immediately whenever it changes (as early as possible)
+		// SID can shift mid-stream, so we save on every change
+		// This allows concurrent sessions to fork with the latest SID
+		if pendingSID != "" && !streamHasError && pendingSID != c.SID {
+			Debug("Flushing SID change: %s -> %s", c.SID, pendingSID)
+			c.SID = pendingSID
+			sidSaved = true
+			if err := saveContext(c); err != nil {
+				Warn("Failed to flush SID: %v", err)
+			}
+		}
+
+		// Write decoded text with error handling
+		if ev.Text != "" {
+			totalOutput += len(ev.Text)
+			if _, err := bufWriter.WriteString(ev.Text); err != nil {
+				Error("Failed to write output: %v", err)
+				return fmt.Errorf("write output: %w", err)
+			}
+			// Flush on newlines for responsiveness
+			if strings.Contains(ev.Text, "\n") {
+				bufWriter.Flush()
+			}
+		}
+		return nil
+	}
 
 	for scanner.Scan() {
 		lineNumber++
 		line := scanner.Text()
 
-		// Detect format on first non-empty line
-		if format == "" && line != "" {
-			format = detectFormat(line)
-			Debug("Detected output format: %s (first char: %c)", format, line[0])
-
+		if logPath == "" && line != "" {
 			// NOW create directories and context after we have first successful output
 			if err := os.MkdirAll(c.DIR, 0o755); err != nil {
-				return fmt.Errorf("create directory %s: %w", c.DIR, err)
+				return result, fmt.Errorf("create directory %s: %w", c.DIR, err)
 			}
 			if err := saveContext(c); err != nil {
 				Warn("Failed to save context: %v", err)
@@ -655,235 +1023,60 @@ func StreamAndLog(c *Context, r io.Reader, w io.Writer) error {
 			// Create log file
 			log3, err := Log3(c)
 			if err != nil {
-				return err
+				return result, err
 			}
-			logFile, err = os.OpenFile(log3, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-			if err != nil {
-				return fmt.Errorf("open log file: %w", err)
+			if err := appendLocked(log3, nil); err != nil {
+				return result, fmt.Errorf("open log file: %w", err)
 			}
+			logPath = log3
 		}
 
 		// Check if we've exceeded output limit
 		if totalOutput >= MaxOutputSize {
 			Warn("Output size limit reached (%d bytes), truncating response", MaxOutputSize)
+			result.Truncated = true
 			if _, err := bufWriter.WriteString("\n[WARNING: Output truncated at 10MB limit]\n"); err != nil {
 				Error("Failed to write truncation warning: %v", err)
 			}
 			break
 		}
 
-		// Handle line based on detected format
-		switch format {
-		case "json":
-			// Parse JSON line
-			var data map[string]interface{}
-			if err := json.Unmarshal([]byte(line), &data); err != nil {
-				// Log warning for malformed JSON with context
-				Warn("Malformed JSON at line %d (length %d): %v", lineNumber, len(line), err)
-				if len(line) > 100 {
-					Debug("Malformed JSON content (first 100 chars): %s...", line[:100])
-				} else {
-					Debug("Malformed JSON content: %s", line)
-				}
-				continue // Skip malformed JSON
-			}
-
-			// Check for error responses using is_error field (no heuristics)
-			msgType, hasType := data["type"].(string)
-			isError, _ := data["is_error"].(bool)
-
-			// Mark stream as having error if we see is_error==true anywhere
-			if isError {
-				streamHasError = true
-				// Clear pending SID immediately - don't save error sessions
-				if pendingSID != "" {
-					Debug("Discarding pending SID %s due to error in stream", pendingSID)
-					pendingSID = ""
-				}
-			}
-
-			// Handle explicit error types (type=="error" or type=="result" with is_error)
-			if hasType && (msgType == "error" || (msgType == "result" && isError)) {
-				// Extract error message
-				var errorMsg string
-
-				// Check for error object
-				if err, ok := data["error"].(map[string]interface{}); ok {
-					if msg, ok := err["message"].(string); ok {
-						errorMsg = msg
-					}
-				}
-
-				// Check for result field (in result type)
-				if errorMsg == "" {
-					if result, ok := data["result"].(string); ok {
-						errorMsg = result
-					}
-				}
-
-				// Check for top-level message field
-				if errorMsg == "" {
-					if msg, ok := data["message"].(string); ok {
-						errorMsg = msg
-					}
-				}
-
-				if errorMsg == "" {
-					errorMsg = "Unknown API error"
-				}
-
-				// Log error but don't update SID
-				Warn("API error response (type=%s, is_error=%v): %s", msgType, isError, errorMsg)
-
-				// Only output error message if it's type=="error"
-				// For type=="result", the assistant message already displayed it
-				if msgType == "error" {
-					if _, err := bufWriter.WriteString(errorMsg + "\n"); err != nil {
-						Error("Failed to write error output: %v", err)
-					}
-					bufWriter.Flush()
-				}
-
-				// Don't write to log file for errors - we don't want to persist failed attempts
-				continue
-			}
-
-			// Write JSON lines with session_id to log immediately
-			// Check both .session_id (Claude) and .sessionId (Codex)
-			// Note: Errors will be logged too, but SID won't be updated (see streamHasError check)
-			_, hasSessionID := data["session_id"]
-			_, hasSessionIdAlt := data["sessionId"]
-			if hasSessionID || hasSessionIdAlt {
-				if _, err := logFile.WriteString(line + "\n"); err != nil {
-					Warn("Failed to write to log file: %v", err)
-					// Continue processing even if logging fails
-				}
-			}
-
-			// Extract and output message text with error recovery
-			var extractedText string
-
-			// Claude CLI format: type=="assistant" with .message.content[].text
-			if msgType, ok := data["type"].(string); ok && msgType == "assistant" {
-				// Collect session ID from assistant message (defer update until stream end)
-				// Only assistant messages indicate an established session
-				// Check both .session_id (Claude) and .sessionId (Codex)
-				sessionIDRaw, hasSessionID := data["session_id"]
-				if !hasSessionID {
-					sessionIDRaw, hasSessionID = data["sessionId"]
-				}
-				if hasSessionID {
-					if sessionIDStr, ok := sessionIDRaw.(string); ok && sessionIDStr != "" {
-						newSID := ID(sessionIDStr)
-						// Only log first time we see a session ID (signal change)
-						if pendingSID == "" && !sidLogged {
-							pendingSID = newSID
-							sidLogged = true
-							Debug("Session established: %s", pendingSID)
-						} else if pendingSID == "" {
-							pendingSID = newSID
-						}
-					}
-				}
-				if msg, ok := data["message"].(map[string]interface{}); ok {
-					if content, ok := msg["content"].([]interface{}); ok {
-						for _, item := range content {
-							if itemMap, ok := item.(map[string]interface{}); ok {
-								if text, ok := itemMap["text"].(string); ok {
-									extractedText += text
-								}
-							}
-						}
-					}
-				}
-			} else if msg, ok := data["message"].(map[string]interface{}); ok {
-				// .message.content[].text at top level
-				if content, ok := msg["content"].([]interface{}); ok {
-					for _, item := range content {
-						if itemMap, ok := item.(map[string]interface{}); ok {
-							if text, ok := itemMap["text"].(string); ok {
-								extractedText += text
-							}
-						}
-					}
-				}
-			} else if msg, ok := data["msg"].(map[string]interface{}); ok {
-				// .msg.message (used by some genera like Codex)
-				if message, ok := msg["message"].(string); ok {
-					extractedText = message
-				}
-			}
-
-			// Save pending SID immediately whenever it changes (as early as possible)
-			// SID can shift mid-stream, so we save on every change
-			// This allows concurrent sessions to fork with the latest SID
-			if pendingSID != "" && !streamHasError && pendingSID != c.SID {
-				Debug("Flushing SID change: %s -> %s", c.SID, pendingSID)
-				c.SID = pendingSID
-				sidSaved = true
-				if err := saveContext(c); err != nil {
-					Warn("Failed to flush SID: %v", err)
-				}
-			}
-
-			// Write extracted text with error handling
-			if extractedText != "" {
-				totalOutput += len(extractedText)
-				if _, err := bufWriter.WriteString(extractedText); err != nil {
-					Error("Failed to write output: %v", err)
-					return fmt.Errorf("write output: %w", err)
-				}
-				// Flush on newlines for responsiveness
-				if strings.Contains(extractedText, "\n") {
-					bufWriter.Flush()
-				}
+		ev, err := decoder.Decode(line)
+		if err != nil {
+			// Log warning for malformed output with context
+			Warn("Malformed output at line %d (length %d): %v", lineNumber, len(line), err)
+			if len(line) > 100 {
+				Debug("Malformed output content (first 100 chars): %s...", line[:100])
+			} else {
+				Debug("Malformed output content: %s", line)
 			}
+			continue // Skip malformed lines
+		}
 
-		case "text", "empty":
-			// Plain text output - display and log as assistant message
-			totalOutput += len(line)
-			if _, err := bufWriter.WriteString(line + "\n"); err != nil {
-				Error("Failed to write output: %v", err)
-				return fmt.Errorf("write output: %w", err)
-			}
-			bufWriter.Flush()
-
-			// Log text as assistant message with proper structure
-			if line != "" {
-				msg := Message{
-					SessionID: c.SID,
-					At:        time.Now(),
-					From:      "assistant",
-					Body:      line,
-				}
-				msgJSON, err := json.Marshal(msg)
-				if err == nil {
-					if _, err := logFile.WriteString(string(msgJSON) + "\n"); err != nil {
-						Warn("Failed to write message to log: %v", err)
-					}
-				}
-			}
+		if err := apply(ev); err == errEarlyFailure {
+			return result, nil
+		} else if err != nil {
+			return result, err
+		}
+	}
 
-		default:
-			// Unknown format - treat as plain text
-			Debug("Unknown format, treating as text: %s", format)
-			totalOutput += len(line)
-			if _, err := bufWriter.WriteString(line + "\n"); err != nil {
-				Error("Failed to write output: %v", err)
-				return fmt.Errorf("write output: %w", err)
-			}
+	// Let decoders that buffer (e.g. whole replies to log) finish, unless
+	// reading failed
+	if f, ok := decoder.(StreamFinisher); ok && scanner.Err() == nil {
+		if err := apply(f.Finish()); err != nil {
+			return result, err
 		}
 	}
 
 	// Final flush
 	if err := bufWriter.Flush(); err != nil {
 		Error("Failed to flush output buffer: %v", err)
-		return fmt.Errorf("flush output: %w", err)
+		return result, fmt.Errorf("flush output: %w", err)
 	}
 
 	if err := scanner.Err(); err != nil {
 		Error("Error reading stream: %v", err)
-		return fmt.Errorf("read stream: %w", err)
+		return result, fmt.Errorf("read stream: %w", err)
 	}
 
 	// Save context at end if we haven't already (e.g., no session ID updates)
@@ -900,59 +1093,54 @@ func StreamAndLog(c *Context, r io.Reader, w io.Writer) error {
 		}
 	}
 
-	return nil
+	return result, nil
 }
 
 // AppendMessage logs a message to the session log in JSONL format.
 func AppendMessage(c *Context, from string, body string) error {
-	log3, err := Log3(c)
-	if err != nil {
-		return err
-	}
-
-	msg := Message{
+	return appendMessage(c, Message{
 		SessionID: c.SID,
 		At:        time.Now(),
 		From:      from,
 		Body:      body,
 		Tags:      nil,
-	}
-
-	line, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
-
-	// Ensure log directory exists
-	if err := os.MkdirAll(filepath.Dir(log3), 0o755); err != nil {
-		return err
-	}
+	})
+}
 
-	f, err := os.OpenFile(log3, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+// Helper functions
 
-	if _, err := f.Write(append(line, '\n')); err != nil {
-		return err
-	}
+// internalEnvPrefix marks the Context.ENV keys aimux sets for the duration
+// of one call (callEnvKey, fallbackEnvKey, forkEnvKey, ...).
+const internalEnvPrefix = "_AIMUX_"
 
-	return nil
+// transientEnvKey returns true if ENV key k only applies to the current
+// call, so it is not saved in context.json for later calls to load. Besides
+// the internal keys, that is the rewind cutoff Rewind sets.
+func transientEnvKey(k string) bool {
+	return strings.HasPrefix(k, internalEnvPrefix) || k == "AIRWD" || k == "AITEMPORAL"
 }
 
-// Helper functions
-
-// saveContext writes context metadata to DIR/context.json.
+// saveContext writes context metadata to DIR/context.json, without the
+// transient ENV keys.
 func saveContext(c *Context) error {
-	data, err := json.Marshal(c)
+	saved := *c
+	saved.ENV = make(map[string]string, len(c.ENV))
+	for k, v := range c.ENV {
+		if !transientEnvKey(k) {
+			saved.ENV[k] = v
+		}
+	}
+	data, err := json.Marshal(&saved)
 	if err != nil {
 		return err
 	}
 	path := filepath.Join(c.DIR, contextFileName)
-	// Write as single line with newline at end (matching log.jsonl format)
+	// Write as single line with newline at end (matching log.jsonl format),
+	// atomically so concurrent readers never see a partial context
 	data = append(data, '\n')
-	return os.WriteFile(path, data, 0o644)
+	return withFileLock(path, func() error {
+		return writeFileAtomic(path, data)
+	})
 }
 
 // hasContent returns true if the file exists and has non-zero size.
@@ -974,6 +1162,7 @@ func hasEstablishedSession(path string) bool {
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineLength)
 	for scanner.Scan() {
 		var msg map[string]interface{}
 		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
@@ -1007,6 +1196,7 @@ func lastSessionID(path string) (ID, error) {
 
 	var lastLine string
 	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineLength)
 	for scanner.Scan() {
 		lastLine = scanner.Text()
 	}
@@ -1197,86 +1387,6 @@ func regexpFindString(pattern, text string) string {
 	return ""
 }
 
-// LoadReferencedContext loads recent messages from a referenced conversation.
-// Returns up to maxMessages recent messages from the conversation's log.
-// Tries multiple log paths: undifferentiated -> architect -> engineer.
-func LoadReferencedContext(refCID ID, maxMessages int) ([]Message, error) {
-	if refCID == "" {
-		return nil, fmt.Errorf("refCID cannot be empty")
-	}
-
-	if maxMessages <= 0 {
-		maxMessages = 20 // Default to 20 messages
-	}
-
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("get home dir: %w", err)
-	}
-
-	// Try multiple log paths in order of preference
-	conversationDir := filepath.Join(homeDir, ".aimux", "conversations", string(refCID), "claude")
-	logPaths := []string{
-		filepath.Join(conversationDir, "log.jsonl"),              // Undifferentiated
-		filepath.Join(conversationDir, "architect", "log.jsonl"), // Architect persona
-		filepath.Join(conversationDir, "engineer", "log.jsonl"),  // Engineer persona
-	}
-
-	var messages []Message
-	var lastErr error
-
-	for _, logPath := range logPaths {
-		messages, err = loadMessagesFromLog(logPath)
-		if err == nil {
-			// Successfully loaded from this path
-			break
-		}
-		lastErr = err
-	}
-
-	if messages == nil {
-		return nil, fmt.Errorf("no logs found for CID %s: %w", refCID, lastErr)
-	}
-
-	// Return last N messages
-	if len(messages) > maxMessages {
-		return messages[len(messages)-maxMessages:], nil
-	}
-	return messages, nil
-}
-
-// loadMessagesFromLog reads and parses a JSONL log file.
-// Returns all messages in chronological order.
-func loadMessagesFromLog(logPath string) ([]Message, error) {
-	file, err := os.Open(logPath)
-	if err != nil {
-		return nil, fmt.Errorf("open log: %w", err)
-	}
-	defer file.Close()
-
-	var messages []Message
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		var msg Message
-		if err := json.Unmarshal([]byte(line), &msg); err != nil {
-			// Skip malformed lines
-			continue
-		}
-		messages = append(messages, msg)
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("scan log: %w", err)
-	}
-
-	return messages, nil
-}
-
 // truncate truncates a string to maxLen characters, adding "..." if truncated.
 // Returns "..." for maxLen <= 3, and empty string for maxLen <= 0.
 func truncate(s string, maxLen int) string {
@@ -2,10 +2,12 @@ package aimux
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestSessions verifies session creation and resumption
@@ -68,6 +70,72 @@ func TestSessions(t *testing.T) {
 		}
 	})
 
+	t.Run("ResumeContext joins existing conversation", func(t *testing.T) {
+		ctx1, err := InitContext("bash", "")
+		if err != nil {
+			t.Fatalf("InitContext() error = %v", err)
+		}
+
+		// Unknown conversation
+		if _, err := ResumeContext(ctx1.CID, "bash", "architect"); err == nil {
+			t.Fatal("ResumeContext() expected error for missing conversation")
+		}
+
+		if err := os.MkdirAll(ctx1.DIR, 0755); err != nil {
+			t.Fatalf("mkdir error = %v", err)
+		}
+		if err := saveContext(ctx1); err != nil {
+			t.Fatalf("saveContext error = %v", err)
+		}
+
+		// New persona in an existing conversation gets a session of its own
+		ctx2, err := ResumeContext(ctx1.CID, "bash", "architect")
+		if err != nil {
+			t.Fatalf("ResumeContext() error = %v", err)
+		}
+		if ctx2.CID != ctx1.CID {
+			t.Errorf("ResumeContext() CID = %v, want %v", ctx2.CID, ctx1.CID)
+		}
+		if ctx2.SID == ctx1.SID {
+			t.Errorf("ResumeContext() SID = %v, want a new session", ctx2.SID)
+		}
+	})
+
+	t.Run("JoinContext joins a conversation before it exists", func(t *testing.T) {
+		cid, err := NewID()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// No history: a session of its own, and nothing created on disk yet
+		ctx1, err := JoinContext(cid, "bash", "engineer")
+		if err != nil {
+			t.Fatalf("JoinContext() error = %v", err)
+		}
+		if ctx1.CID != cid || ctx1.SID == cid {
+			t.Errorf("JoinContext() CID = %v, SID = %v, want CID %v and a new session", ctx1.CID, ctx1.SID, cid)
+		}
+		dir0, _ := Dir0(ctx1)
+		if _, err := os.Stat(dir0); err == nil {
+			t.Errorf("JoinContext() should not create directory %v", dir0)
+		}
+
+		// Once saved, joining again resumes that session
+		if err := os.MkdirAll(ctx1.DIR, 0755); err != nil {
+			t.Fatalf("mkdir error = %v", err)
+		}
+		if err := saveContext(ctx1); err != nil {
+			t.Fatalf("saveContext error = %v", err)
+		}
+		ctx2, err := JoinContext(cid, "bash", "engineer")
+		if err != nil {
+			t.Fatalf("JoinContext() error = %v", err)
+		}
+		if ctx2.SID != ctx1.SID {
+			t.Errorf("JoinContext() SID = %v, want %v", ctx2.SID, ctx1.SID)
+		}
+	})
+
 	t.Run("Branch creates new session ID", func(t *testing.T) {
 		ctx, err := InitContext("bash", "")
 		if err != nil {
@@ -162,6 +230,31 @@ func TestBuildSessionFlags(t *testing.T) {
 		}
 	})
 
+	t.Run("near-cap prompt line keeps the session established", func(t *testing.T) {
+		ctx, err := InitContext("bash", "engineer")
+		if err != nil {
+			t.Fatalf("InitContext() error = %v", err)
+		}
+
+		// Escaped, a stdin_cap-sized prompt of quotes is twice as long
+		log2, _ := Log2(ctx)
+		writeTestLog(t, log2,
+			Message{SessionID: ctx.SID, From: "user", Body: strings.Repeat(`"`, defaultStdinCap)},
+			Message{SessionID: ctx.SID, From: "assistant", Body: "ok"},
+		)
+
+		if sid, err := lastSessionID(log2); err != nil || sid != ctx.SID {
+			t.Errorf("lastSessionID() = %v, %v, want %v", sid, err, ctx.SID)
+		}
+		flags, isNew, err := buildSessionFlags(ctx, testGenus, personaVars)
+		if err != nil {
+			t.Fatalf("buildSessionFlags() error = %v", err)
+		}
+		if want := []string{"--resume", string(ctx.SID)}; isNew || strings.Join(flags, " ") != strings.Join(want, " ") {
+			t.Errorf("buildSessionFlags() = %v (new=%v), want %v", flags, isNew, want)
+		}
+	})
+
 	t.Run("log1 established, log2 exists with Branch flags returns Branch", func(t *testing.T) {
 		ctx, err := InitContext("bash", "")
 		if err != nil {
@@ -777,3 +870,144 @@ func TestInferFlowHintsMalformedMarkdown(t *testing.T) {
 		})
 	}
 }
+
+// TestRewind verifies SID selection and branching for temporal rewind
+func TestRewind(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	ctx, err := InitContext("claude", "architect")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+
+	sidA := ctx.SID
+	sidB, _ := NewID()
+	base := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	log3, _ := Log3(ctx)
+	if err := os.MkdirAll(filepath.Dir(log3), 0o755); err != nil {
+		t.Fatalf("mkdir error = %v", err)
+	}
+	lines := []string{
+		fmt.Sprintf(`{"session_id":%q,"at":%q,"from":"user","body":"first"}`, sidA, base.Format(time.RFC3339)),
+		fmt.Sprintf(`{"type":"assistant","session_id":%q,"message":{"content":[{"type":"text","text":"one"}]}}`, sidA),
+		fmt.Sprintf(`{"session_id":%q,"at":%q,"from":"user","body":"second"}`, sidB, base.Add(time.Hour).Format(time.RFC3339)),
+		fmt.Sprintf(`{"type":"assistant","session_id":%q,"message":{"content":[{"type":"text","text":"two"}]}}`, sidB),
+	}
+	if err := os.WriteFile(log3, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("write log error = %v", err)
+	}
+
+	t.Run("SessionAt picks SID active at cutoff", func(t *testing.T) {
+		sid, err := SessionAt(ctx, base.Add(30*time.Minute))
+		if err != nil {
+			t.Fatalf("SessionAt() error = %v", err)
+		}
+		if sid != sidA {
+			t.Errorf("SessionAt() = %v, want %v", sid, sidA)
+		}
+
+		sid, err = SessionAt(ctx, base.Add(2*time.Hour))
+		if err != nil {
+			t.Fatalf("SessionAt() error = %v", err)
+		}
+		if sid != sidB {
+			t.Errorf("SessionAt() = %v, want %v", sid, sidB)
+		}
+	})
+
+	t.Run("SessionAt before any activity fails", func(t *testing.T) {
+		if _, err := SessionAt(ctx, base.Add(-time.Hour)); err == nil {
+			t.Error("SessionAt() before first message should fail")
+		}
+	})
+
+	t.Run("Rewind forks from selected SID", func(t *testing.T) {
+		if err := Rewind(ctx, base.Add(30*time.Minute)); err != nil {
+			t.Fatalf("Rewind() error = %v", err)
+		}
+		if ctx.SID != sidA {
+			t.Errorf("Rewind() SID = %v, want %v", ctx.SID, sidA)
+		}
+
+		genus := GenusConfig{
+			Args: GenusArgs{
+				Resume: []string{"--resume", "{{sid}}"},
+				Branch: []string{"--resume", "{{sid}}", "--fork-session"},
+				New:    []string{"--session-id", "{{sid}}"},
+			},
+		}
+		flags, isNew, err := buildSessionFlags(ctx, genus, PersonaVars{})
+		if err != nil {
+			t.Fatalf("buildSessionFlags() error = %v", err)
+		}
+		want := []string{"--resume", string(sidA), "--fork-session"}
+		if isNew || strings.Join(flags, " ") != strings.Join(want, " ") {
+			t.Errorf("buildSessionFlags() = %v (new=%v), want %v", flags, isNew, want)
+		}
+
+		// The cutoff applies to this call only; the next one does not load it
+		if err := saveContext(ctx); err != nil {
+			t.Fatalf("saveContext() error = %v", err)
+		}
+		next, err := ResumeContext(ctx.CID, "claude", "architect")
+		if err != nil {
+			t.Fatalf("ResumeContext() error = %v", err)
+		}
+		if next.ENV["AIRWD"] != "" || next.ENV["AITEMPORAL"] != "" || ctx.ENV["AIRWD"] == "" {
+			t.Errorf("saved ENV = %v, in memory %v", next.ENV, ctx.ENV)
+		}
+	})
+
+	t.Run("sessionAt reports turns after the cutoff", func(t *testing.T) {
+		cutoff := base.Add(30 * time.Minute)
+		if sid, later, err := sessionAt(ctx, cutoff); err != nil || sid != sidA || later {
+			t.Errorf("sessionAt() = %v, %v, %v, want %v without later turns", sid, later, err, sidA)
+		}
+
+		// Back in session A after session B: a fork of A keeps this turn
+		f, err := os.OpenFile(log3, os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(f, "{\"session_id\":%q,\"at\":%q,\"from\":\"user\",\"body\":\"third\"}\n", sidA, base.Add(2*time.Hour).Format(time.RFC3339))
+		f.Close()
+		if sid, later, err := sessionAt(ctx, cutoff); err != nil || sid != sidA || !later {
+			t.Errorf("sessionAt() = %v, %v, %v, want %v with later turns", sid, later, err, sidA)
+		}
+		if sid, later, err := sessionAt(ctx, base.Add(90*time.Minute)); err != nil || sid != sidB || later {
+			t.Errorf("sessionAt() = %v, %v, %v, want %v without later turns", sid, later, err, sidB)
+		}
+
+		// A CLI genus would fork session A with that turn, so the rewind is refused
+		genus := GenusConfig{
+			Args: GenusArgs{
+				Resume: []string{"--resume", "{{sid}}"},
+				Branch: []string{"--resume", "{{sid}}", "--fork-session"},
+				New:    []string{"--session-id", "{{sid}}"},
+			},
+		}
+		if flags, _, err := buildSessionFlags(ctx, genus, PersonaVars{}); err == nil {
+			t.Errorf("buildSessionFlags() = %v, want rewind refused", flags)
+		}
+		genus.Args.Branch = nil
+		if flags, _, err := buildSessionFlags(ctx, genus, PersonaVars{}); err == nil {
+			t.Errorf("buildSessionFlags() without branch args = %v, want rewind refused", flags)
+		}
+	})
+}
+
+func TestRedactArgv(t *testing.T) {
+	argv := []string{"claude", "--append-system-prompt", "PARTNER PROTOCOL", "-p", "fix the bug", "--flag=fix the bug"}
+	got := redactArgv(argv, "PARTNER PROTOCOL", "fix the bug")
+	want := []string{"claude", "--append-system-prompt", "<system-prompt>", "-p", "<prompt>", "--flag=<prompt>"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("redactArgv() = %q, want %q", got, want)
+	}
+	if argv[2] != "PARTNER PROTOCOL" {
+		t.Error("redactArgv() modified its input")
+	}
+}
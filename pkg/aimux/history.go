@@ -0,0 +1,536 @@
+package aimux
+
+// history.go - Conversation log discovery and referenced context loading
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sessionTagPrefix marks the Message.Tags entry naming the session (Tag3)
+// whose log a message was loaded from.
+const sessionTagPrefix = "tag:"
+
+// logRef locates one session log within a conversation.
+type logRef struct {
+	GEN  string
+	MOD  string
+	Path string
+}
+
+// Tag returns the canonical tag (Tag3) of the session that wrote the log.
+func (r logRef) Tag() string {
+	return Tag3(&Context{GEN: r.GEN, MOD: r.MOD})
+}
+
+// conversationLogs discovers every genus and persona log under a conversation:
+// Dir1/log.jsonl (undifferentiated), Dir1/$MOD/log.jsonl, and Dir1/-/log.jsonl
+// placeholders written via Log2. Results are sorted by genus, then persona.
+func conversationLogs(cid ID) ([]logRef, error) {
+	home, err := homeDir()
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(home, aimuxDir, conversationsDir, string(cid))
+
+	genera, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("read conversation %s: %w", cid, err)
+	}
+
+	var refs []logRef
+	for _, g := range genera {
+		if !g.IsDir() {
+			continue
+		}
+		dir1 := filepath.Join(root, g.Name())
+		if path := filepath.Join(dir1, logFileName); fileExists(path) {
+			refs = append(refs, logRef{GEN: g.Name(), Path: path})
+		}
+
+		mods, err := os.ReadDir(dir1)
+		if err != nil {
+			Warn("Failed to read genus directory %s: %v", dir1, err)
+			continue
+		}
+		for _, m := range mods {
+			if !m.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir1, m.Name(), logFileName)
+			if !fileExists(path) {
+				continue
+			}
+			mod := m.Name()
+			if mod == emptyModPlaceholder {
+				mod = ""
+			}
+			refs = append(refs, logRef{GEN: g.Name(), MOD: mod, Path: path})
+		}
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		if refs[i].GEN != refs[j].GEN {
+			return refs[i].GEN < refs[j].GEN
+		}
+		return refs[i].MOD < refs[j].MOD
+	})
+	return refs, nil
+}
+
+// loadConversationMessages merges the messages of every log in a conversation
+// in chronological order. Each message is annotated with the tag of the log it
+// came from (a "tag:" entry appended to Tags). Messages without a timestamp inherit the
+// timestamp of the preceding message in the same log so they sort with it.
+func loadConversationMessages(cid ID) ([]Message, error) {
+	refs, err := conversationLogs(cid)
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("no logs found for CID %s", cid)
+	}
+
+	var messages []Message
+	for _, ref := range refs {
+		msgs, err := loadMessagesFromLog(ref.Path)
+		if err != nil {
+			Warn("Failed to load %s: %v", ref.Path, err)
+			continue
+		}
+		var lastAt time.Time
+		for _, msg := range msgs {
+			if msg.At.IsZero() {
+				msg.At = lastAt
+			} else {
+				lastAt = msg.At
+			}
+			msg.Tags = append(msg.Tags, sessionTagPrefix+ref.Tag())
+			messages = append(messages, msg)
+		}
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].At.Before(messages[j].At)
+	})
+	return messages, nil
+}
+
+// messageTag returns the session tag annotated by loadConversationMessages,
+// or empty string if the message was not annotated.
+func messageTag(msg Message) string {
+	for i := len(msg.Tags) - 1; i >= 0; i-- {
+		if strings.HasPrefix(msg.Tags[i], sessionTagPrefix) {
+			return strings.TrimPrefix(msg.Tags[i], sessionTagPrefix)
+		}
+	}
+	return ""
+}
+
+// SessionInfo summarizes one genus/persona session within a conversation.
+type SessionInfo struct {
+	GEN          string    `json:"gen"`
+	MOD          string    `json:"mod"`
+	SID          ID        `json:"sid"`
+	Messages     int       `json:"messages"`
+	LastActivity time.Time `json:"last_activity"`
+}
+
+// ConversationInfo summarizes a conversation for listing. SID is the current
+// session of the most recently active genus/persona.
+type ConversationInfo struct {
+	CID          ID            `json:"cid"`
+	Genera       []string      `json:"genera"`
+	Personas     []string      `json:"personas"`
+	SID          ID            `json:"sid"`
+	Messages     int           `json:"messages"`
+	FirstPrompt  string        `json:"first_prompt"`
+	LastActivity time.Time     `json:"last_activity"`
+	Sessions     []SessionInfo `json:"sessions"`
+}
+
+// ListConversations summarizes every conversation under ~/.aimux/conversations.
+// Conversations that cannot be read are skipped with a warning.
+func ListConversations() ([]ConversationInfo, error) {
+	cids, err := conversationIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []ConversationInfo
+	for _, cid := range cids {
+		info, err := DescribeConversation(cid)
+		if err != nil {
+			Warn("Skipping conversation %s: %v", cid, err)
+			continue
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+// DescribeConversation summarizes one conversation from its session logs
+// (Log3) and the context.json in each session directory (Dir2).
+func DescribeConversation(cid ID) (*ConversationInfo, error) {
+	refs, err := conversationLogs(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ConversationInfo{CID: cid, Genera: []string{}, Personas: []string{}}
+	var firstPromptAt time.Time
+	sessions := map[string]*SessionInfo{}
+	var order []string
+
+	for _, ref := range refs {
+		key := ref.Tag()
+		session, ok := sessions[key]
+		if !ok {
+			ctx := &Context{CID: cid, GEN: ref.GEN, MOD: ref.MOD}
+			session = &SessionInfo{GEN: ref.GEN, MOD: ref.MOD, SID: savedSessionID(ctx)}
+			sessions[key] = session
+			order = append(order, key)
+		}
+
+		msgs, err := loadMessagesFromLog(ref.Path)
+		if err != nil {
+			Warn("Failed to load %s: %v", ref.Path, err)
+			continue
+		}
+		session.Messages += len(msgs)
+		for _, msg := range msgs {
+			if msg.At.After(session.LastActivity) {
+				session.LastActivity = msg.At
+			}
+			if msg.From == "user" && !msg.At.IsZero() && (firstPromptAt.IsZero() || msg.At.Before(firstPromptAt)) {
+				firstPromptAt = msg.At
+				info.FirstPrompt = msg.Body
+			}
+		}
+		// Logs of raw stream lines may carry no timestamps; fall back to mtime
+		if session.LastActivity.IsZero() {
+			if st, err := os.Stat(ref.Path); err == nil {
+				session.LastActivity = st.ModTime()
+			}
+		}
+	}
+
+	genera := map[string]bool{}
+	personas := map[string]bool{}
+	for _, key := range order {
+		session := sessions[key]
+		info.Sessions = append(info.Sessions, *session)
+		info.Messages += session.Messages
+		if session.LastActivity.After(info.LastActivity) {
+			info.LastActivity = session.LastActivity
+			info.SID = session.SID
+		}
+		if !genera[session.GEN] {
+			genera[session.GEN] = true
+			info.Genera = append(info.Genera, session.GEN)
+		}
+		if session.MOD != "" && !personas[session.MOD] {
+			personas[session.MOD] = true
+			info.Personas = append(info.Personas, session.MOD)
+		}
+	}
+	sort.Strings(info.Personas)
+
+	return info, nil
+}
+
+// savedSessionID returns the SID stored in the session's context.json,
+// or empty ID if none was saved.
+func savedSessionID(c *Context) ID {
+	dir, err := Dir2(c)
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(dir, contextFileName))
+	if err != nil {
+		return ""
+	}
+	var saved Context
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return ""
+	}
+	return saved.SID
+}
+
+// LoadReferencedContext loads recent messages from a referenced conversation.
+// Returns up to maxMessages recent messages merged chronologically across
+// every genus and persona log of the conversation.
+func LoadReferencedContext(refCID ID, maxMessages int) ([]Message, error) {
+	return LoadReferencedContextBefore(refCID, maxMessages, time.Time{})
+}
+
+// LoadReferencedContextBefore is like LoadReferencedContext but drops messages
+// logged after cutoff, and those of unknown time (zero cutoff keeps
+// everything). Used by temporal rewind.
+func LoadReferencedContextBefore(refCID ID, maxMessages int, cutoff time.Time) ([]Message, error) {
+	if refCID == "" {
+		return nil, fmt.Errorf("refCID cannot be empty")
+	}
+
+	if maxMessages <= 0 {
+		maxMessages = 20 // Default to 20 messages
+	}
+
+	messages, err := loadConversationMessages(refCID)
+	if err != nil {
+		return nil, fmt.Errorf("no logs found for CID %s: %w", refCID, err)
+	}
+
+	if !cutoff.IsZero() {
+		kept := messages[:0]
+		for _, msg := range messages {
+			if !msg.At.IsZero() && !msg.At.After(cutoff) {
+				kept = append(kept, msg)
+			}
+		}
+		messages = kept
+	}
+
+	// Return last N messages
+	if len(messages) > maxMessages {
+		return messages[len(messages)-maxMessages:], nil
+	}
+	return messages, nil
+}
+
+// loadMessagesFromLog reads and parses a JSONL log file.
+// Returns all messages in chronological order.
+func loadMessagesFromLog(logPath string) ([]Message, error) {
+	file, err := os.Open(logPath)
+	if err != nil {
+		return nil, fmt.Errorf("open log: %w", err)
+	}
+	defer file.Close()
+
+	messages, err := ReadMessages(file)
+	if err != nil {
+		return nil, fmt.Errorf("scan log: %w", err)
+	}
+	return messages, nil
+}
+
+// ReadMessages reads a JSONL log and normalizes every line into transcript
+// messages (see parseLogLine). Malformed and uninteresting lines are skipped.
+// A prompt logged again by the same call, completed with its stdin (see
+// logPrompt), replaces the body and tags of the first in its place.
+func ReadMessages(r io.Reader) ([]Message, error) {
+	var messages []Message
+	prompts := make(map[string]int) // Index of each call's prompt
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineLength)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		for _, msg := range parseLogLine(line) {
+			call := tagValue(msg.Tags, callTagPrefix)
+			if msg.From == "user" && call != "" {
+				if i, ok := prompts[call]; ok {
+					messages[i].Body, messages[i].Tags = msg.Body, msg.Tags
+					continue
+				}
+				prompts[call] = len(messages)
+			}
+			messages = append(messages, msg)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// parseLogLine normalizes one log line into zero or more transcript messages.
+// It understands three shapes:
+//
+//   - native Message records written by AppendMessage ("from"/"body")
+//   - Claude stream-json events ("type": system/assistant/user/result/error)
+//   - Codex JSONL events (legacy "msg" envelopes and "item.*" events)
+//
+// Assistant text yields From "assistant"; tool calls and results yield
+// "tool_use"/"tool_result"; Codex reasoning yields "reasoning"; failures
+// yield "error". Successful Claude result events are skipped because they
+// repeat the final assistant text.
+func parseLogLine(line []byte) []Message {
+	var data map[string]interface{}
+	if err := json.Unmarshal(line, &data); err != nil {
+		return nil
+	}
+
+	// Native Message shape
+	if _, ok := data["from"]; ok {
+		var msg Message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil
+		}
+		return []Message{msg}
+	}
+
+	base := Message{SessionID: lineSessionID(data)}
+	if at, ok := lineTime(data); ok {
+		base.At = at
+	}
+	emit := func(from, body string) Message {
+		msg := base
+		msg.From = from
+		msg.Body = body
+		return msg
+	}
+
+	// Codex legacy envelope: {"id": ..., "msg": {"type": ..., ...}}
+	if msg, ok := data["msg"].(map[string]interface{}); ok {
+		text, _ := msg["message"].(string)
+		switch msg["type"] {
+		case "agent_message":
+			return []Message{emit("assistant", text)}
+		case "agent_reasoning":
+			text, _ = msg["text"].(string)
+			return []Message{emit("reasoning", text)}
+		case "error", "stream_error":
+			return []Message{emit("error", text)}
+		}
+		return nil
+	}
+
+	msgType, _ := data["type"].(string)
+	switch msgType {
+	case "assistant", "user":
+		return claudeContentMessages(data, emit)
+
+	case "result":
+		if isError, _ := data["is_error"].(bool); isError {
+			text, _ := data["result"].(string)
+			return []Message{emit("error", text)}
+		}
+
+	case "error":
+		return []Message{emit("error", eventErrorMessage(data))}
+
+	case "turn.failed":
+		return []Message{emit("error", eventErrorMessage(data))}
+
+	case "item.completed":
+		item, _ := data["item"].(map[string]interface{})
+		text, _ := item["text"].(string)
+		switch item["type"] {
+		case "agent_message":
+			return []Message{emit("assistant", text)}
+		case "reasoning":
+			return []Message{emit("reasoning", text)}
+		case "command_execution":
+			command, _ := item["command"].(string)
+			output, _ := item["aggregated_output"].(string)
+			return []Message{emit("tool_use", command), emit("tool_result", output)}
+		case "error":
+			msg, _ := item["message"].(string)
+			return []Message{emit("error", msg)}
+		}
+	}
+	return nil
+}
+
+// claudeContentMessages converts the .message.content[] blocks of a Claude
+// assistant or user event into messages. Adjacent text blocks are joined.
+func claudeContentMessages(data map[string]interface{}, emit func(from, body string) Message) []Message {
+	msgType, _ := data["type"].(string)
+	message, ok := data["message"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	// User prompts may carry plain string content
+	if text, ok := message["content"].(string); ok {
+		return []Message{emit(msgType, text)}
+	}
+
+	content, _ := message["content"].([]interface{})
+	var messages []Message
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			messages = append(messages, emit(msgType, text.String()))
+			text.Reset()
+		}
+	}
+	for _, item := range content {
+		block, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch block["type"] {
+		case "text":
+			s, _ := block["text"].(string)
+			text.WriteString(s)
+		case "tool_use":
+			flush()
+			name, _ := block["name"].(string)
+			input, _ := json.Marshal(block["input"])
+			messages = append(messages, emit("tool_use", name+": "+string(input)))
+		case "tool_result":
+			flush()
+			messages = append(messages, emit("tool_result", toolResultText(block["content"])))
+		}
+	}
+	flush()
+	return messages
+}
+
+// toolResultText flattens tool_result content (string or text blocks).
+func toolResultText(content interface{}) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			if block, ok := item.(map[string]interface{}); ok {
+				if s, ok := block["text"].(string); ok {
+					parts = append(parts, s)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// eventErrorMessage extracts the message from an error event, checking
+// .error.message, then .message.
+func eventErrorMessage(data map[string]interface{}) string {
+	if e, ok := data["error"].(map[string]interface{}); ok {
+		if msg, ok := e["message"].(string); ok {
+			return msg
+		}
+	}
+	if msg, ok := data["message"].(string); ok {
+		return msg
+	}
+	return "Unknown error"
+}
+
+// lineSessionID extracts the backend session ID of a log line from
+// .session_id (Claude), .sessionId or .thread_id (Codex).
+func lineSessionID(data map[string]interface{}) ID {
+	for _, key := range []string{"session_id", "sessionId", "thread_id"} {
+		if s, ok := data[key].(string); ok && s != "" {
+			return ID(s)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,325 @@
+package aimux
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestLog writes messages as JSONL to path, creating parent directories.
+func writeTestLog(t *testing.T, path string, lines ...interface{}) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir error = %v", err)
+	}
+	var sb strings.Builder
+	for _, line := range lines {
+		switch v := line.(type) {
+		case string:
+			sb.WriteString(v)
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				t.Fatalf("marshal error = %v", err)
+			}
+			sb.Write(data)
+		}
+		sb.WriteString("\n")
+	}
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		t.Fatalf("write log error = %v", err)
+	}
+}
+
+// TestLoadReferencedContextAcrossGenera verifies discovery and merge of all logs
+func TestLoadReferencedContextAcrossGenera(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	cid := ID("12345678-1234-4123-8234-123456789abc")
+	root := filepath.Join(tmpDir, ".aimux", "conversations", string(cid))
+	base := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	writeTestLog(t, filepath.Join(root, "codex", "-", "log.jsonl"),
+		Message{SessionID: cid, At: base.Add(2 * time.Minute), From: "assistant", Body: "codex answer"},
+	)
+	writeTestLog(t, filepath.Join(root, "claude", "architect", "log.jsonl"),
+		Message{SessionID: cid, At: base.Add(1 * time.Minute), From: "user", Body: "architect question"},
+	)
+	writeTestLog(t, filepath.Join(root, "bash", "log.jsonl"),
+		Message{SessionID: cid, At: base, From: "user", Body: "echo first"},
+		Message{SessionID: cid, At: base.Add(3 * time.Minute), From: "assistant", Body: "first"},
+	)
+
+	refs, err := conversationLogs(cid)
+	if err != nil {
+		t.Fatalf("conversationLogs() error = %v", err)
+	}
+	var tags []string
+	for _, ref := range refs {
+		tags = append(tags, ref.Tag())
+	}
+	if got, want := strings.Join(tags, ","), "bash,architect~claude,codex"; got != want {
+		t.Errorf("conversationLogs() tags = %v, want %v", got, want)
+	}
+
+	messages, err := LoadReferencedContext(cid, 10)
+	if err != nil {
+		t.Fatalf("LoadReferencedContext() error = %v", err)
+	}
+
+	wantBodies := []string{"echo first", "architect question", "codex answer", "first"}
+	wantTags := []string{"bash", "architect~claude", "codex", "bash"}
+	if len(messages) != len(wantBodies) {
+		t.Fatalf("LoadReferencedContext() returned %d messages, want %d", len(messages), len(wantBodies))
+	}
+	for i, msg := range messages {
+		if msg.Body != wantBodies[i] {
+			t.Errorf("message %d body = %q, want %q", i, msg.Body, wantBodies[i])
+		}
+		if tag := messageTag(msg); tag != wantTags[i] {
+			t.Errorf("message %d tag = %q, want %q", i, tag, wantTags[i])
+		}
+	}
+
+	// Limit keeps the most recent messages
+	messages, err = LoadReferencedContext(cid, 2)
+	if err != nil {
+		t.Fatalf("LoadReferencedContext() error = %v", err)
+	}
+	if len(messages) != 2 || messages[0].Body != "codex answer" {
+		t.Errorf("LoadReferencedContext(limit 2) = %+v", messages)
+	}
+}
+
+// TestLoadReferencedContextBefore verifies rewind cutoff filtering
+func TestLoadReferencedContextBefore(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	ctx, err := InitContext("claude", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+	base := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+	log3, _ := Log3(ctx)
+	if err := os.MkdirAll(filepath.Dir(log3), 0o755); err != nil {
+		t.Fatalf("mkdir error = %v", err)
+	}
+	// Stream-json lines carry no timestamp: one before any timestamped line
+	// has no known time, one after "late" inherits its time
+	streamLine := func(body string) string {
+		return fmt.Sprintf(`{"type":"assistant","session_id":%q,"message":{"content":[{"type":"text","text":%q}]}}`, ctx.SID, body)
+	}
+	lines := []string{streamLine("undated")}
+	for i, body := range []string{"early", "middle", "late"} {
+		msg := Message{SessionID: ctx.SID, At: base.Add(time.Duration(i) * time.Hour), From: "user", Body: body}
+		data, _ := json.Marshal(msg)
+		lines = append(lines, string(data))
+	}
+	lines = append(lines, streamLine("late answer"))
+	if err := os.WriteFile(log3, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("write log error = %v", err)
+	}
+
+	messages, err := LoadReferencedContextBefore(ctx.CID, 10, base.Add(90*time.Minute))
+	if err != nil {
+		t.Fatalf("LoadReferencedContextBefore() error = %v", err)
+	}
+	if len(messages) != 2 || messages[1].Body != "middle" {
+		t.Errorf("LoadReferencedContextBefore() = %+v, want early and middle", messages)
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		wantFrom  []string
+		wantBody  []string
+		wantSID   ID
+		checkTime bool
+	}{
+		{
+			name:      "native message",
+			line:      `{"session_id":"s1","at":"2026-01-15T10:00:00Z","from":"user","body":"hello"}`,
+			wantFrom:  []string{"user"},
+			wantBody:  []string{"hello"},
+			wantSID:   "s1",
+			checkTime: true,
+		},
+		{
+			name:     "claude system init skipped",
+			line:     `{"type":"system","subtype":"init","session_id":"s1"}`,
+			wantFrom: nil,
+		},
+		{
+			name:     "claude assistant text and tool use",
+			line:     `{"type":"assistant","session_id":"s1","message":{"content":[{"type":"text","text":"Let me "},{"type":"text","text":"check."},{"type":"tool_use","name":"Bash","input":{"command":"ls"}}]}}`,
+			wantFrom: []string{"assistant", "tool_use"},
+			wantBody: []string{"Let me check.", `Bash: {"command":"ls"}`},
+			wantSID:  "s1",
+		},
+		{
+			name:     "claude tool result",
+			line:     `{"type":"user","session_id":"s1","message":{"content":[{"type":"tool_result","content":[{"type":"text","text":"a.go"}]}]}}`,
+			wantFrom: []string{"tool_result"},
+			wantBody: []string{"a.go"},
+		},
+		{
+			name:     "claude successful result skipped",
+			line:     `{"type":"result","is_error":false,"result":"done","session_id":"s1"}`,
+			wantFrom: nil,
+		},
+		{
+			name:     "claude error result",
+			line:     `{"type":"result","is_error":true,"result":"Overloaded","session_id":"s1"}`,
+			wantFrom: []string{"error"},
+			wantBody: []string{"Overloaded"},
+		},
+		{
+			name:     "codex legacy agent message",
+			line:     `{"id":"0","msg":{"type":"agent_message","message":"hi from codex"},"sessionId":"s2"}`,
+			wantFrom: []string{"assistant"},
+			wantBody: []string{"hi from codex"},
+			wantSID:  "s2",
+		},
+		{
+			name:     "codex item agent message",
+			line:     `{"type":"item.completed","item":{"id":"item_1","type":"agent_message","text":"answer"}}`,
+			wantFrom: []string{"assistant"},
+			wantBody: []string{"answer"},
+		},
+		{
+			name:     "codex item reasoning",
+			line:     `{"type":"item.completed","item":{"id":"item_0","type":"reasoning","text":"thinking"}}`,
+			wantFrom: []string{"reasoning"},
+			wantBody: []string{"thinking"},
+		},
+		{
+			name:     "codex turn failed",
+			line:     `{"type":"turn.failed","error":{"message":"rate limited"}}`,
+			wantFrom: []string{"error"},
+			wantBody: []string{"rate limited"},
+		},
+		{
+			name:     "malformed line",
+			line:     `{not json`,
+			wantFrom: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseLogLine([]byte(tt.line))
+			if len(got) != len(tt.wantFrom) {
+				t.Fatalf("parseLogLine() returned %d messages (%+v), want %d", len(got), got, len(tt.wantFrom))
+			}
+			for i, msg := range got {
+				if msg.From != tt.wantFrom[i] {
+					t.Errorf("message %d From = %q, want %q", i, msg.From, tt.wantFrom[i])
+				}
+				if msg.Body != tt.wantBody[i] {
+					t.Errorf("message %d Body = %q, want %q", i, msg.Body, tt.wantBody[i])
+				}
+				if tt.wantSID != "" && msg.SessionID != tt.wantSID {
+					t.Errorf("message %d SessionID = %q, want %q", i, msg.SessionID, tt.wantSID)
+				}
+				if tt.checkTime && msg.At.IsZero() {
+					t.Errorf("message %d At is zero", i)
+				}
+			}
+		})
+	}
+}
+
+// TestReadMessagesMixedLog verifies a log mixing AppendMessage and stream-json lines
+func TestReadMessagesMixedLog(t *testing.T) {
+	log := strings.Join([]string{
+		`{"session_id":"s1","at":"2026-01-15T10:00:00Z","from":"user","body":"What is 2+2?"}`,
+		`{"type":"system","subtype":"init","session_id":"s1"}`,
+		`{"type":"assistant","session_id":"s1","message":{"content":[{"type":"text","text":"4"}]}}`,
+		`{"type":"result","is_error":false,"result":"4","session_id":"s1"}`,
+	}, "\n")
+
+	messages, err := ReadMessages(strings.NewReader(log))
+	if err != nil {
+		t.Fatalf("ReadMessages() error = %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("ReadMessages() returned %d messages, want 2: %+v", len(messages), messages)
+	}
+	if messages[0].From != "user" || messages[1].From != "assistant" || messages[1].Body != "4" {
+		t.Errorf("ReadMessages() = %+v", messages)
+	}
+}
+
+// TestListConversations verifies conversation summaries built from logs and context.json
+func TestListConversations(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	if infos, err := ListConversations(); err != nil || len(infos) != 0 {
+		t.Fatalf("ListConversations() on empty home = %v, %v", infos, err)
+	}
+
+	base := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+	ctx, err := InitContext("claude", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+	log3, _ := Log3(ctx)
+	writeTestLog(t, log3,
+		Message{SessionID: ctx.SID, At: base, From: "user", Body: "Design a cache"},
+		`{"type":"assistant","session_id":"`+string(ctx.SID)+`","message":{"content":[{"type":"text","text":"Sure"}]}}`,
+	)
+	if err := saveContext(ctx); err != nil {
+		t.Fatalf("saveContext() error = %v", err)
+	}
+
+	arch := &Context{CID: ctx.CID, SID: ctx.SID, GEN: "claude", MOD: "architect"}
+	arch.DIR, _ = Dir2(arch)
+	log3, _ = Log3(arch)
+	writeTestLog(t, log3,
+		Message{SessionID: arch.SID, At: base.Add(time.Hour), From: "user", Body: "Review the cache"},
+		Message{SessionID: arch.SID, At: base.Add(time.Hour + time.Minute), From: "assistant", Body: "Looks good"},
+	)
+
+	infos, err := ListConversations()
+	if err != nil {
+		t.Fatalf("ListConversations() error = %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("ListConversations() returned %d conversations, want 1", len(infos))
+	}
+
+	info := infos[0]
+	if info.CID != ctx.CID {
+		t.Errorf("CID = %v, want %v", info.CID, ctx.CID)
+	}
+	if info.Messages != 4 {
+		t.Errorf("Messages = %d, want 4", info.Messages)
+	}
+	if info.FirstPrompt != "Design a cache" {
+		t.Errorf("FirstPrompt = %q, want %q", info.FirstPrompt, "Design a cache")
+	}
+	if !info.LastActivity.Equal(base.Add(time.Hour + time.Minute)) {
+		t.Errorf("LastActivity = %v, want %v", info.LastActivity, base.Add(time.Hour+time.Minute))
+	}
+	if strings.Join(info.Genera, ",") != "claude" || strings.Join(info.Personas, ",") != "architect" {
+		t.Errorf("Genera = %v, Personas = %v", info.Genera, info.Personas)
+	}
+	if len(info.Sessions) != 2 || info.Sessions[0].SID != ctx.SID {
+		t.Errorf("Sessions = %+v", info.Sessions)
+	}
+}
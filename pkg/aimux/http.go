@@ -0,0 +1,347 @@
+package aimux
+
+// http.go - Genera served over HTTP chat APIs instead of exec'd CLIs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Genus types (GenusConfig.Type). CLI genera leave the type empty.
+const (
+	GenusTypeExec      = "exec"      // Exec'd CLI (the default)
+	GenusTypeOpenAI    = "openai"    // OpenAI-compatible /chat/completions
+	GenusTypeOllama    = "ollama"    // Ollama /api/chat
+	GenusTypeAnthropic = "anthropic" // Anthropic Messages API
+)
+
+// maxErrorBody caps how much of an HTTP error response is read.
+const maxErrorBody = 64 * 1024
+
+// ChatRequest is one call to a chat API: the system prompt and the history
+// ending with the new prompt.
+type ChatRequest struct {
+	Model    string
+	System   string
+	Messages []ChatMessage
+	APIKey   string
+	Vars     PersonaVars // The persona's vars, for endpoint-specific settings
+}
+
+// ChatEndpoint builds the streaming HTTP request for a chat call to a genus.
+type ChatEndpoint func(ctx context.Context, genus GenusConfig, req ChatRequest) (*http.Request, error)
+
+var chatEndpoints = map[string]ChatEndpoint{
+	GenusTypeOpenAI:    openAIRequest,
+	GenusTypeOllama:    ollamaRequest,
+	GenusTypeAnthropic: anthropicRequest,
+}
+
+// RegisterChatEndpoint makes an HTTP chat API available as a genus "type",
+// replacing any endpoint registered under the same name.
+func RegisterChatEndpoint(genusType string, endpoint ChatEndpoint) {
+	chatEndpoints[genusType] = endpoint
+}
+
+// isHTTPGenus reports whether genus is served over HTTP.
+func isHTTPGenus(genus GenusConfig) bool {
+	return genus.Type != "" && genus.Type != GenusTypeExec
+}
+
+// HTTPStatusError is a non-2xx response from an HTTP genus.
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.Message == "" {
+		return "HTTP " + e.Status
+	}
+	return fmt.Sprintf("HTTP %s: %s", e.Status, e.Message)
+}
+
+// httpClient performs HTTP genus requests; timeouts come from the call context.
+var httpClient = &http.Client{}
+
+// HTTPStream is the response body of an HTTP genus call. Like
+// LazyCommandStream, the request is only built (reading stdin) and sent on
+// the first Read.
+type HTTPStream struct {
+	url        string
+	models     []string // Primary model, then the fallback (if any)
+	ctx        context.Context
+	cancel     context.CancelFunc
+	newRequest func(ctx context.Context, model string) (*http.Request, error)
+
+	once     sync.Once
+	body     io.ReadCloser
+	err      error
+	fallback *Fallback
+}
+
+// Fallback reports the fallback to model2, if start made one.
+func (hs *HTTPStream) Fallback() *Fallback {
+	return hs.fallback
+}
+
+// Argv returns the request line, recorded in place of a command line.
+func (hs *HTTPStream) Argv() []string {
+	return []string{"POST", hs.url}
+}
+
+func (hs *HTTPStream) Read(p []byte) (int, error) {
+	hs.once.Do(func() {
+		hs.body, hs.err = hs.start()
+	})
+	if hs.err != nil {
+		return 0, hs.err
+	}
+	return hs.body.Read(p)
+}
+
+func (hs *HTTPStream) Close() error {
+	hs.once.Do(func() {}) // Never started
+	defer hs.cancel()
+	if hs.body != nil {
+		return hs.body.Close()
+	}
+	return nil
+}
+
+// start sends the request for each model in turn, falling back to the next
+// while the response status says the model is unavailable (see
+// fallbackStatus). Nothing has been streamed at that point, so the fallback
+// is transparent.
+func (hs *HTTPStream) start() (io.ReadCloser, error) {
+	var statusErr *HTTPStatusError
+	for i, model := range hs.models {
+		body, err := hs.send(model)
+		if !errors.As(err, &statusErr) || !fallbackStatus(statusErr.StatusCode) || i == len(hs.models)-1 {
+			return body, err
+		}
+		Warn("Model %s failed (%v), falling back to %s", model, err, hs.models[i+1])
+		hs.fallback = &Fallback{From: model, To: hs.models[i+1], Error: err.Error()}
+	}
+	return nil, fmt.Errorf("no model to call")
+}
+
+func (hs *HTTPStream) send(model string) (io.ReadCloser, error) {
+	req, err := hs.newRequest(hs.ctx, model)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, &HTTPStatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Message:    httpErrorMessage(data),
+		}
+	}
+	return resp.Body, nil
+}
+
+// fallbackStatus reports whether a response status means the model cannot
+// answer right now (unknown, rate limited, overloaded or failing) rather than
+// that the request is bad.
+func fallbackStatus(code int) bool {
+	return code == http.StatusNotFound || code == http.StatusTooManyRequests || code >= 500
+}
+
+// httpErrorMessage extracts the message of an error response body:
+// .error.message, .error (a string), or the body itself.
+func httpErrorMessage(body []byte) string {
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err == nil {
+		if e, ok := data["error"].(map[string]interface{}); ok {
+			if msg, ok := e["message"].(string); ok {
+				return msg
+			}
+		}
+		if msg, ok := data["error"].(string); ok {
+			return msg
+		}
+	}
+	return truncate(strings.TrimSpace(string(body)), 500)
+}
+
+// callHTTPGenus starts a call to an HTTP genus. There is no backend session:
+// the history is rebuilt from the session logs (see ChatHistory) and sent
+// with every request. Branching forks the history into a new SID.
+func callHTTPGenus(ctx context.Context, c *Context, genus GenusConfig, personaVars PersonaVars, cmdArgs string, stdin io.Reader) (io.ReadCloser, error) {
+	endpoint, ok := chatEndpoints[genus.Type]
+	if !ok {
+		return nil, fmt.Errorf("genus %s: unknown type %q (valid: %s)", c.GEN, genus.Type, strings.Join(chatEndpointTypes(), ", "))
+	}
+	if genus.URL == "" {
+		return nil, fmt.Errorf("genus %s has no url configured", c.GEN)
+	}
+	model := personaVars["model"]
+	if model == "" {
+		return nil, fmt.Errorf("genus %s has no model for persona %q", c.GEN, c.MOD)
+	}
+	var apiKey string
+	if genus.APIKeyEnv != "" {
+		if apiKey = callGetenv(c, genus.APIKeyEnv); apiKey == "" {
+			return nil, fmt.Errorf("genus %s: $%s is not set", c.GEN, genus.APIKeyEnv)
+		}
+	}
+
+	mode, err := chooseSession(c, true)
+	if err != nil {
+		return nil, err
+	}
+	delete(c.ENV, forkEnvKey)
+	var history []ChatMessage
+	switch mode {
+	case sessionResume:
+		history, err = ChatHistory(c, c.SID, time.Time{})
+	case sessionBranch:
+		// Fork at the rewind time if rewinding, otherwise now
+		at := time.Now()
+		if rwd, perr := time.Parse(time.RFC3339, c.ENV["AIRWD"]); perr == nil {
+			at = rwd
+		}
+		var parent ID
+		if parent, err = branchParent(c); err != nil {
+			break
+		}
+		if history, err = ChatHistory(c, parent, at); err != nil {
+			break
+		}
+		if c.SID, err = NewID(); err != nil {
+			break
+		}
+		// StreamAndLog saves the new SID only if the call succeeds
+		c.ENV[forkEnvKey] = forkTag(parent, at)
+		Debug("Forking chat session %s at %s into %s", parent, at.Format(time.RFC3339), c.SID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load chat history: %w", err)
+	}
+
+	models := []string{model}
+	if model2 := personaVars["model2"]; model2 != "" && model2 != model {
+		models = append(models, model2)
+	}
+
+	// The prompt is read once, on the first request, and reused by fallbacks
+	var prompt *string
+	system := systemPromptFor(c)
+	reqCtx, cancel := context.WithTimeout(ctx, callTimeout(c))
+	return &HTTPStream{
+		url:    genus.URL,
+		models: models,
+		ctx:    reqCtx,
+		cancel: cancel,
+		newRequest: func(ctx context.Context, model string) (*http.Request, error) {
+			if prompt == nil {
+				p, err := readPrompt(cmdArgs, stdin)
+				if err != nil {
+					return nil, err
+				}
+				prompt = &p
+			}
+			return endpoint(ctx, genus, ChatRequest{
+				Model:    model,
+				System:   system,
+				Messages: append(history, ChatMessage{Role: "user", Content: *prompt}),
+				APIKey:   apiKey,
+				Vars:     personaVars,
+			})
+		},
+	}, nil
+}
+
+// chatEndpointTypes lists the registered HTTP genus types.
+func chatEndpointTypes() []string {
+	types := make([]string, 0, len(chatEndpoints))
+	for t := range chatEndpoints {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// readPrompt joins cmdArgs and stdin into one prompt, the way CLI genera
+// receive them.
+func readPrompt(cmdArgs string, stdin io.Reader) (string, error) {
+	if stdin == nil {
+		return cmdArgs, nil
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", fmt.Errorf("read stdin: %w", err)
+	}
+	if cmdArgs == "" {
+		return string(data), nil
+	}
+	return cmdArgs + "\n\n" + string(data), nil
+}
+
+// newJSONRequest builds a POST request with a JSON body.
+func newJSONRequest(ctx context.Context, url string, body interface{}) (*http.Request, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// sseData returns the payload of a server-sent events "data:" line.
+func sseData(line string) (string, bool) {
+	if !strings.HasPrefix(line, "data:") {
+		return "", false
+	}
+	return strings.TrimPrefix(line[len("data:"):], " "), true
+}
+
+// chatReply accumulates a reply streamed in deltas, to be output as it
+// arrives and logged whole when the stream ends.
+type chatReply struct {
+	c      *Context
+	text   strings.Builder
+	failed bool
+}
+
+// Finish logs the complete reply, unless the stream failed. A trailing
+// newline is output if the reply lacks one.
+func (r *chatReply) Finish() StreamEvent {
+	if r.failed || r.text.Len() == 0 {
+		return StreamEvent{}
+	}
+	reply := r.text.String()
+	ev := StreamEvent{Log: replyMessage(r.c, reply)}
+	if !strings.HasSuffix(reply, "\n") {
+		ev.Text = "\n"
+	}
+	return ev
+}
+
+// fail reports an error event: it is output, and the reply is not logged.
+func (r *chatReply) fail(msg string) StreamEvent {
+	Warn("API error response: %s", msg)
+	r.failed = true
+	return StreamEvent{Text: msg + "\n", Failed: true}
+}
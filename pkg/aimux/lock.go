@@ -0,0 +1,78 @@
+package aimux
+
+// lock.go - Advisory file locking and atomic writes for concurrent calls
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockSuffix names the sidecar lock file of a conversation file. The lock is
+// not taken on the file itself because atomic writes replace it.
+const lockSuffix = ".lock"
+
+// withFileLock runs fn holding an exclusive advisory lock (flock) for path,
+// serializing it with other processes mutating path. Locks are not
+// reentrant: fn must not lock path again.
+func withFileLock(path string, fn func() error) error {
+	lf, err := os.OpenFile(path+lockSuffix, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("open lock: %w", err)
+	}
+	defer lf.Close()
+	if err := syscall.Flock(int(lf.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock %s: %w", path, err)
+	}
+	defer syscall.Flock(int(lf.Fd()), syscall.LOCK_UN)
+	return fn()
+}
+
+// appendLocked appends data to path (created if missing) under its lock, so
+// concurrent appends and rewrites never interleave.
+func appendLocked(path string, data []byte) error {
+	return withFileLock(path, func() error {
+		return appendFile(path, data)
+	})
+}
+
+// appendFile appends data to path, creating it if missing.
+func appendFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeFileAtomic replaces path with data via a temporary file renamed over
+// it, so readers see either the old or the new content, never a mix.
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileAtomicPerm(path, data, 0o644)
+}
+
+// writeFileAtomicPerm is writeFileAtomic with the file's permissions.
+func writeFileAtomicPerm(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
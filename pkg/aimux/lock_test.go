@@ -0,0 +1,126 @@
+package aimux
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentCallHelper is not a test: TestConcurrentCalls runs the test
+// binary with AIMUX_TEST_HELPER set to make one call in its conversation.
+func TestConcurrentCallHelper(t *testing.T) {
+	cid := os.Getenv("AIMUX_TEST_HELPER")
+	if cid == "" {
+		t.Skip("helper process")
+	}
+	ctx, err := ResumeContext(ID(cid), "bash", "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	n := os.Getenv("AIMUX_TEST_N")
+	stdin := strings.NewReader(strings.Repeat("input-"+n+"\n", 50))
+	if _, err := RunCall(context.Background(), ctx, "echo answer-"+n+"; cat", stdin, &bytes.Buffer{}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// TestConcurrentCalls runs many aimux processes against one conversation and
+// checks that no log line, call record or context write is lost or torn
+func TestConcurrentCalls(t *testing.T) {
+	if testing.Short() {
+		t.Skip("spawns processes")
+	}
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	t.Setenv("AICALL", "")
+
+	ctx, err := InitContext("bash", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+	if _, err := RunCall(context.Background(), ctx, "echo start", nil, &bytes.Buffer{}); err != nil {
+		t.Fatalf("RunCall() error = %v", err)
+	}
+
+	const procs = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, procs)
+	for i := 0; i < procs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestConcurrentCallHelper$")
+			cmd.Env = append(os.Environ(), "AIMUX_TEST_HELPER="+string(ctx.CID), fmt.Sprintf("AIMUX_TEST_N=%d", i))
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs <- fmt.Errorf("helper %d: %v: %s", i, err, out)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// Every prompt (completed with its stdin) and every answer line survives
+	log1, err := Log1(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(log1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	prompts := map[string]bool{}
+	answers := map[string]int{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxLineLength)
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatalf("torn log line %q: %v", scanner.Text(), err)
+		}
+		switch {
+		case msg.From == "user":
+			prompts[msg.Body] = true
+		case strings.HasPrefix(msg.Body, "answer-"), strings.HasPrefix(msg.Body, "input-"):
+			answers[strings.TrimPrefix(strings.TrimPrefix(msg.Body, "answer-"), "input-")]++
+		}
+	}
+	for i := 0; i < procs; i++ {
+		n := fmt.Sprint(i)
+		want := "echo answer-" + n + "; cat\n\n" + strings.Repeat("input-"+n+"\n", 50)
+		if !prompts[want] {
+			t.Errorf("prompt of call %d missing or incomplete", i)
+		}
+		if answers[n] != 51 {
+			t.Errorf("call %d logged %d answer lines, want 51", i, answers[n])
+		}
+	}
+
+	records, err := LoadCalls(ctx.CID)
+	if err != nil || len(records) != procs+1 {
+		t.Errorf("LoadCalls() = %d records, %v, want %d", len(records), err, procs+1)
+	}
+	data, err := os.ReadFile(filepath.Join(ctx.DIR, contextFileName))
+	var saved Context
+	if err != nil || json.Unmarshal(data, &saved) != nil || saved.CID != ctx.CID {
+		t.Errorf("context.json = %q, %v", data, err)
+	}
+}
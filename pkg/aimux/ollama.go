@@ -0,0 +1,63 @@
+package aimux
+
+// ollama.go - Ollama /api/chat genus for local models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ollamaRequest builds a streaming POST to <url>/api/chat, with the system
+// prompt as the first message.
+func ollamaRequest(ctx context.Context, genus GenusConfig, req ChatRequest) (*http.Request, error) {
+	messages := append([]ChatMessage{{Role: "system", Content: req.System}}, req.Messages...)
+	httpReq, err := newJSONRequest(ctx, strings.TrimRight(genus.URL, "/")+"/api/chat", map[string]interface{}{
+		"model":    req.Model,
+		"messages": messages,
+		"stream":   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", "application/x-ndjson")
+	// Ollama needs no key, but proxies in front of it may
+	if req.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+req.APIKey)
+	}
+	return httpReq, nil
+}
+
+// ollamaDecoder decodes /api/chat NDJSON chunks, outputting content deltas as
+// they arrive and logging the whole reply when the stream ends.
+type ollamaDecoder struct {
+	chatReply
+}
+
+func (d *ollamaDecoder) Decode(line string) (StreamEvent, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &data); err != nil {
+		return StreamEvent{}, err
+	}
+	if msg, ok := data["error"].(string); ok {
+		return d.fail(msg), nil
+	}
+
+	var ev StreamEvent
+	if message, ok := data["message"].(map[string]interface{}); ok {
+		if content, ok := message["content"].(string); ok && content != "" {
+			d.text.WriteString(content)
+			ev.Text = content
+		}
+	}
+	// The final chunk ("done") reports the token counts
+	if done, _ := data["done"].(bool); done {
+		ev.Usage = &Usage{
+			InputTokens:  jsonInt(data["prompt_eval_count"]),
+			OutputTokens: jsonInt(data["eval_count"]),
+			Turns:        1,
+		}
+	}
+	return ev, nil
+}
@@ -0,0 +1,119 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestOllamaGenus verifies NDJSON streaming and history replay across session
+// branches of an Ollama genus
+func TestOllamaGenus(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	t.Setenv("AICALL", "")
+
+	server := &chatTestServer{}
+	server.reply = func(w http.ResponseWriter, body map[string]interface{}) {
+		if body["model"] == "missing" {
+			fmt.Fprintln(w, `{"error":"model 'missing' not found"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, delta := range []string{"Reply", " ", fmt.Sprint(len(server.requests))} {
+			fmt.Fprintf(w, "{\"model\":%q,\"message\":{\"role\":\"assistant\",\"content\":%q},\"done\":false}\n", body["model"], delta)
+		}
+		fmt.Fprintf(w, "{\"model\":%q,\"message\":{\"role\":\"assistant\",\"content\":\"\"},\"done\":true,\"prompt_eval_count\":42,\"eval_count\":7}\n", body["model"])
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	writeChatConfig(t, tmpDir, "ollama", GenusConfig{
+		Name:   "ollama",
+		Type:   GenusTypeOllama,
+		URL:    ts.URL + "/",
+		Format: StreamFormatOllama,
+		Personas: map[string]PersonaVars{
+			"":          {"model": "llama-test"},
+			"architect": {"model": "qwen-test"},
+			"qa":        {"model": "missing"},
+		},
+	})
+
+	call := func(cid ID, mod, prompt string) (*CallRecord, string, error) {
+		t.Helper()
+		var ctx *Context
+		var err error
+		if cid == "" {
+			ctx, err = InitContext("ollama", mod)
+		} else {
+			ctx, err = ResumeContext(cid, "ollama", mod)
+		}
+		if err != nil {
+			t.Fatalf("context error = %v", err)
+		}
+		var out bytes.Buffer
+		rec, err := RunCall(context.Background(), ctx, prompt, nil, &out)
+		return rec, out.String(), err
+	}
+
+	rec, out, err := call("", "", "one")
+	if err != nil {
+		t.Fatalf("RunCall() error = %v", err)
+	}
+	if out != "Reply 1\n" {
+		t.Errorf("RunCall() output = %q", out)
+	}
+	if want := (Usage{InputTokens: 42, OutputTokens: 7, Turns: 1}); rec.Usage == nil || *rec.Usage != want {
+		t.Errorf("RunCall() usage = %+v, want %+v", rec.Usage, want)
+	}
+	if len(rec.Argv) != 2 || rec.Argv[1] != ts.URL+"/" {
+		t.Errorf("RunCall() argv = %q", rec.Argv)
+	}
+	cid := rec.CID
+
+	// The architect forks the undifferentiated session with its history
+	if _, _, err := call(cid, "architect", "two"); err != nil {
+		t.Fatalf("RunCall(architect) error = %v", err)
+	}
+	// Errors streamed in the body fail the call without a turn
+	if _, out, err := call(cid, "qa", "three"); err == nil || out != "model 'missing' not found\n" {
+		t.Errorf("RunCall(qa) output = %q, error = %v", out, err)
+	}
+	// The undifferentiated session does not see the architect's turn
+	if _, _, err := call(cid, "", "four"); err != nil {
+		t.Fatalf("RunCall(resume) error = %v", err)
+	}
+	// The architect's session continues from its own history
+	if _, _, err := call(cid, "architect", "five"); err != nil {
+		t.Fatalf("RunCall(architect resume) error = %v", err)
+	}
+
+	tests := []struct {
+		request int
+		want    string
+	}{
+		{0, "system:PARTNER PROTOCOL|user:one"},
+		{1, "system:PARTNER PROTOCOL|user:one|assistant:Reply 1|user:two"},
+		{2, "system:PARTNER PROTOCOL|user:one|assistant:Reply 1|user:three"},
+		{3, "system:PARTNER PROTOCOL|user:one|assistant:Reply 1|user:four"},
+		{4, "system:PARTNER PROTOCOL|user:one|assistant:Reply 1|user:two|assistant:Reply 2|user:five"},
+	}
+	for _, tt := range tests {
+		if got := server.roles(t, tt.request); got != tt.want {
+			t.Errorf("request %d messages = %s, want %s", tt.request+1, got, tt.want)
+		}
+	}
+	if !strings.HasSuffix(server.headers[0].Get("Accept"), "ndjson") || server.headers[0].Get("Authorization") != "" {
+		t.Errorf("request headers = %v", server.headers[0])
+	}
+}
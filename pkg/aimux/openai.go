@@ -0,0 +1,72 @@
+package aimux
+
+// openai.go - OpenAI-compatible chat completions genus
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// openAIRequest builds a streaming POST to <url>/chat/completions, with the
+// system prompt as the first message and usage requested in the final chunk.
+func openAIRequest(ctx context.Context, genus GenusConfig, req ChatRequest) (*http.Request, error) {
+	messages := append([]ChatMessage{{Role: "system", Content: req.System}}, req.Messages...)
+	httpReq, err := newJSONRequest(ctx, strings.TrimRight(genus.URL, "/")+"/chat/completions", map[string]interface{}{
+		"model":          req.Model,
+		"messages":       messages,
+		"stream":         true,
+		"stream_options": map[string]bool{"include_usage": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", "text/event-stream")
+	if req.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+req.APIKey)
+	}
+	return httpReq, nil
+}
+
+// openAIDecoder decodes chat completion chunks, outputting content deltas as
+// they arrive and logging the whole reply when the stream ends.
+type openAIDecoder struct {
+	chatReply
+}
+
+func (d *openAIDecoder) Decode(line string) (StreamEvent, error) {
+	payload, ok := sseData(line)
+	if !ok || payload == "[DONE]" {
+		return StreamEvent{}, nil
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		return StreamEvent{}, err
+	}
+	if _, ok := data["error"]; ok {
+		return d.fail(httpErrorMessage([]byte(payload))), nil
+	}
+
+	var ev StreamEvent
+	if usage, ok := data["usage"].(map[string]interface{}); ok {
+		details, _ := usage["prompt_tokens_details"].(map[string]interface{})
+		cached := jsonInt(details["cached_tokens"])
+		// prompt_tokens includes the cached tokens
+		ev.Usage = &Usage{
+			InputTokens:          jsonInt(usage["prompt_tokens"]) - cached,
+			CacheReadInputTokens: cached,
+			OutputTokens:         jsonInt(usage["completion_tokens"]),
+			Turns:                1,
+		}
+	}
+	if choices, ok := data["choices"].([]interface{}); ok && len(choices) > 0 {
+		choice, _ := choices[0].(map[string]interface{})
+		delta, _ := choice["delta"].(map[string]interface{})
+		if content, ok := delta["content"].(string); ok {
+			d.text.WriteString(content)
+			ev.Text = content
+		}
+	}
+	return ev, nil
+}
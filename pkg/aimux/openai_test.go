@@ -0,0 +1,277 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chatTestServer records the chat requests it receives and answers each with
+// reply, or with status if non-zero.
+type chatTestServer struct {
+	requests []map[string]interface{}
+	headers  []http.Header
+	reply    func(w http.ResponseWriter, body map[string]interface{})
+	status   int
+}
+
+func (s *chatTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.requests = append(s.requests, body)
+	s.headers = append(s.headers, r.Header.Clone())
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		fmt.Fprint(w, `{"error":{"message":"slow down"}}`)
+		return
+	}
+	s.reply(w, body)
+}
+
+// roles summarizes the messages of request i as "role:content" pairs.
+func (s *chatTestServer) roles(t *testing.T, i int) string {
+	t.Helper()
+	if i >= len(s.requests) {
+		t.Fatalf("server got %d requests, want request %d", len(s.requests), i)
+	}
+	messages, _ := s.requests[i]["messages"].([]interface{})
+	var parts []string
+	for _, m := range messages {
+		msg, _ := m.(map[string]interface{})
+		content, _ := msg["content"].(string)
+		if msg["role"] == "system" && len(content) > 16 {
+			content = content[:16]
+		}
+		parts = append(parts, fmt.Sprintf("%s:%s", msg["role"], content))
+	}
+	return strings.Join(parts, "|")
+}
+
+// writeChatConfig configures genus gen in the test HOME.
+func writeChatConfig(t *testing.T, home, gen string, genus GenusConfig) {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"genera": map[string]GenusConfig{gen: genus}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLog(t, filepath.Join(home, ".aimux", "config.json"), string(data))
+}
+
+// TestOpenAIGenus verifies streaming, history replay and failures of an
+// OpenAI-compatible genus
+func TestOpenAIGenus(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	t.Setenv("AICALL", "")
+	t.Setenv("TEST_OPENAI_KEY", "sk-test")
+
+	server := &chatTestServer{}
+	server.reply = func(w http.ResponseWriter, body map[string]interface{}) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		n := len(server.requests)
+		for _, delta := range []string{"Answer", " number ", fmt.Sprint(n)} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", delta)
+		}
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":120,\"completion_tokens\":8,\"prompt_tokens_details\":{\"cached_tokens\":100}}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	writeChatConfig(t, tmpDir, "openai", GenusConfig{
+		Name:      "openai",
+		Type:      GenusTypeOpenAI,
+		URL:       ts.URL + "/v1",
+		APIKeyEnv: "TEST_OPENAI_KEY",
+		Format:    StreamFormatOpenAI,
+		Personas:  map[string]PersonaVars{"": {"model": "gpt-test"}},
+	})
+
+	ctx, err := InitContext("openai", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+
+	// Deltas stream through; the request carries model, key and system prompt
+	var out bytes.Buffer
+	rec, err := RunCall(context.Background(), ctx, "first question", nil, &out)
+	if err != nil {
+		t.Fatalf("RunCall() error = %v", err)
+	}
+	if got := out.String(); got != "Answer number 1\n" {
+		t.Errorf("RunCall() output = %q", got)
+	}
+	if got := server.roles(t, 0); got != "system:PARTNER PROTOCOL|user:first question" {
+		t.Errorf("request 1 messages = %s", got)
+	}
+	if server.requests[0]["model"] != "gpt-test" || server.requests[0]["stream"] != true {
+		t.Errorf("request 1 = %v", server.requests[0])
+	}
+	if got := server.headers[0].Get("Authorization"); got != "Bearer sk-test" {
+		t.Errorf("Authorization = %q", got)
+	}
+	want := Usage{InputTokens: 20, CacheReadInputTokens: 100, OutputTokens: 8, Turns: 1}
+	if rec.Usage == nil || *rec.Usage != want {
+		t.Errorf("RunCall() usage = %+v, want %+v", rec.Usage, want)
+	}
+	if len(rec.Argv) != 2 || rec.Argv[1] != ts.URL+"/v1" {
+		t.Errorf("RunCall() argv = %q", rec.Argv)
+	}
+
+	// Failed calls report the API error and leave no turn in the history
+	server.status = http.StatusTooManyRequests
+	_, err = RunCall(context.Background(), ctx, "doomed question", nil, &out)
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 429 || statusErr.Message != "slow down" {
+		t.Errorf("RunCall(429) error = %v, want HTTPStatusError 429", err)
+	}
+	server.status = 0
+
+	// Later calls replay the history, with piped input joined to the prompt
+	resumed, err := ResumeContext(ctx.CID, "openai", "")
+	if err != nil {
+		t.Fatalf("ResumeContext() error = %v", err)
+	}
+	out.Reset()
+	if _, err := RunCall(context.Background(), resumed, "second", strings.NewReader("piped"), &out); err != nil {
+		t.Fatalf("RunCall(resume) error = %v", err)
+	}
+	if got := server.roles(t, 2); got != "system:PARTNER PROTOCOL|user:first question|assistant:Answer number 1|user:second\n\npiped" {
+		t.Errorf("request 3 messages = %s", got)
+	}
+
+	msgs, err := Transcript(ctx.CID, TranscriptFilter{})
+	if err != nil {
+		t.Fatalf("Transcript() error = %v", err)
+	}
+	var got []string
+	for _, msg := range msgs {
+		got = append(got, msg.From+":"+msg.Body)
+	}
+	if want := "user:first question,assistant:Answer number 1,user:doomed question,user:second\n\npiped,assistant:Answer number 3"; strings.Join(got, ",") != want {
+		t.Errorf("Transcript() = %q, want %q", strings.Join(got, ","), want)
+	}
+
+	// A missing API key fails before anything is sent
+	t.Setenv("TEST_OPENAI_KEY", "")
+	if _, err := RunCall(context.Background(), resumed, "no key", nil, &out); err == nil || !strings.Contains(err.Error(), "TEST_OPENAI_KEY") {
+		t.Errorf("RunCall(no key) error = %v", err)
+	}
+	if len(server.requests) != 3 {
+		t.Errorf("server got %d requests, want 3", len(server.requests))
+	}
+}
+
+// TestChatHistoryFork verifies forked chat sessions inherit their parent's
+// history up to the fork
+func TestChatHistoryFork(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	c := &Context{CID: "12345678-1234-4123-8234-123456789abc", GEN: "openai", MOD: "architect"}
+	parent := ID("11111111-1234-4123-8234-123456789abc")
+	child := ID("22222222-1234-4123-8234-123456789abc")
+	base := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+
+	log1, _ := Log1(c)
+	log3, _ := Log3(c)
+	turn := func(sid ID, call string, minute int, prompt, reply string, extra ...string) []interface{} {
+		return []interface{}{
+			Message{SessionID: sid, At: at(minute), From: "user", Body: prompt, Tags: []string{"call:" + call}},
+			Message{SessionID: sid, At: at(minute + 1), From: "assistant", Body: reply, Tags: append([]string{"call:" + call}, extra...)},
+		}
+	}
+	// The undifferentiated session, then a persona session forked from it
+	// at minute 5 (before its last turn)
+	writeTestLog(t, log1, append(append(
+		turn(parent, "a", 0, "p1", "r1"),
+		turn(parent, "b", 2, "p2", "r2")...),
+		turn(parent, "c", 10, "p3", "r3")...)...)
+	writeTestLog(t, log3, append(
+		turn(child, "d", 20, "q1", "s1", "fork:"+forkTag(parent, at(5))),
+		Message{SessionID: child, At: at(30), From: "user", Body: "unanswered", Tags: []string{"call:e"}})...)
+
+	tests := []struct {
+		sid  ID
+		want string
+	}{
+		{parent, "p1,r1,p2,r2,p3,r3"},
+		{child, "p1,r1,p2,r2,q1,s1"},
+		{"33333333-1234-4123-8234-123456789abc", ""},
+	}
+	for _, tt := range tests {
+		history, err := ChatHistory(c, tt.sid, at(60))
+		if err != nil {
+			t.Fatalf("ChatHistory(%s) error = %v", tt.sid, err)
+		}
+		var got []string
+		for _, msg := range history {
+			got = append(got, msg.Content)
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("ChatHistory(%s) = %q, want %q", tt.sid, strings.Join(got, ","), tt.want)
+		}
+	}
+}
+
+// TestChatHistorySpilledStdin verifies that prompts whose stdin was spilled
+// to a sidecar file are replayed in full
+func TestChatHistorySpilledStdin(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	c := &Context{CID: "12345678-1234-4123-8234-123456789abc", GEN: "openai", MOD: "architect"}
+	sid := ID("11111111-1234-4123-8234-123456789abc")
+	base := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+	stdin := "line one\n\nline two\nline three\n"
+
+	var lines []interface{}
+	for i, cmdArgs := range []string{"review\n\nthis", ""} {
+		call := fmt.Sprint(i)
+		pr := newPromptRecorder(strings.NewReader(stdin), 12, filepath.Join(tmpDir, "stdin", call+".txt"))
+		if _, err := io.ReadAll(pr); err != nil {
+			t.Fatal(err)
+		}
+		pr.Close()
+		prompt := pr.Message(Message{SessionID: sid, At: base.Add(time.Duration(i) * time.Minute), From: "user", Tags: []string{"call:" + call}}, cmdArgs)
+		if !strings.Contains(prompt.Body, "[stdin truncated") {
+			t.Fatalf("prompt %d not spilled: %q", i, prompt.Body)
+		}
+		lines = append(lines, prompt, Message{SessionID: sid, At: prompt.At.Add(time.Second), From: "assistant", Body: "ok", Tags: []string{"call:" + call}})
+	}
+	log3, _ := Log3(c)
+	writeTestLog(t, log3, lines...)
+
+	history, err := ChatHistory(c, sid, time.Time{})
+	if err != nil || len(history) != 4 {
+		t.Fatalf("ChatHistory() = %+v, %v", history, err)
+	}
+	if want := "review\n\nthis\n\n" + stdin; history[0].Content != want {
+		t.Errorf("ChatHistory() prompt 0 = %q, want %q", history[0].Content, want)
+	}
+	if history[2].Content != stdin {
+		t.Errorf("ChatHistory() prompt 1 = %q, want %q", history[2].Content, stdin)
+	}
+}
@@ -0,0 +1,124 @@
+package aimux
+
+// policy.go - Declarative call-policy evaluation
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	policyAllow = "allow"
+	policyDeny  = "deny"
+)
+
+// CheckPolicy evaluates the call-policy rules in order against the context.
+// The first matching rule decides: "allow" returns nil, "deny" returns a
+// BlockingError with the rule's code (default 1) and rendered message.
+// Calls matching no rule are allowed.
+func (cfg *Config) CheckPolicy(c *Context) error {
+	for _, rule := range cfg.Policy.Rules {
+		if !cfg.matchRule(rule, c) {
+			continue
+		}
+		Debug("Policy rule %q matched (action=%s)", rule.Name, rule.Action)
+
+		switch rule.Action {
+		case policyAllow:
+			return nil
+		case policyDeny:
+			code := rule.Code
+			if code == 0 {
+				code = 1
+			}
+			return &BlockingError{
+				Code:    code,
+				Message: cfg.renderPolicyMessage(rule, c),
+			}
+		default:
+			return fmt.Errorf("policy rule %q: unknown action %q", rule.Name, rule.Action)
+		}
+	}
+	return nil
+}
+
+// matchRule reports whether every condition set on the rule holds for c.
+func (cfg *Config) matchRule(rule PolicyRule, c *Context) bool {
+	topMod, topGen := splitTag(c.TOP)
+
+	if !matchPattern(rule.Top, topMod) || !matchPattern(rule.TopGen, topGen) {
+		return false
+	}
+	if !matchPattern(rule.Mod, c.MOD) || !matchPattern(rule.Gen, c.GEN) {
+		return false
+	}
+	if rule.MinLvl != nil && c.LVL < *rule.MinLvl {
+		return false
+	}
+	if rule.MaxLvl != nil && c.LVL > *rule.MaxLvl {
+		return false
+	}
+	if rule.OverDepth && c.LVL < cfg.DepthLimit(c.GEN, c.MOD) {
+		return false
+	}
+	if rule.Self && (c.TAG == "" || c.TAG != c.TOP) {
+		return false
+	}
+	if rule.NotDelegatee && (topMod == "" || cfg.CanDelegate(topMod, c.MOD)) {
+		return false
+	}
+	return true
+}
+
+// matchPattern matches a rule field against a value:
+//   - "" matches anything
+//   - "-" matches only an empty value (undifferentiated, like Log2 paths)
+//   - anything else is a path.Match glob that never matches an empty value,
+//     so "*" means "any non-empty value"
+func matchPattern(pattern, value string) bool {
+	switch pattern {
+	case "":
+		return true
+	case emptyModPlaceholder:
+		return value == ""
+	}
+	if value == "" {
+		return false
+	}
+	ok, err := path.Match(pattern, value)
+	if err != nil {
+		Warn("Invalid policy pattern %q: %v", pattern, err)
+		return false
+	}
+	return ok
+}
+
+// splitTag splits a tag into persona and genus parts. A tag without a tilde
+// is an undifferentiated genus; an empty tag is the main user.
+func splitTag(tag string) (mod, gen string) {
+	if !strings.Contains(tag, "~") {
+		return "", tag
+	}
+	parts := strings.SplitN(tag, "~", 2)
+	return parts[0], parts[1]
+}
+
+// renderPolicyMessage substitutes {{top}}, {{tag}}, {{lvl}}, {{max_depth}},
+// {{mod}} and {{gen}} into the rule message.
+func (cfg *Config) renderPolicyMessage(rule PolicyRule, c *Context) string {
+	msg := rule.Message
+	if msg == "" {
+		msg = fmt.Sprintf("call blocked by policy rule %q", rule.Name)
+	}
+	vars := map[string]string{
+		"top":       SigTop(c),
+		"tag":       SigTag(c),
+		"lvl":       strconv.Itoa(c.LVL),
+		"max_depth": strconv.Itoa(cfg.DepthLimit(c.GEN, c.MOD)),
+		"mod":       c.MOD,
+		"gen":       c.GEN,
+	}
+	return RenderFlags([]string{msg}, vars)[0]
+}
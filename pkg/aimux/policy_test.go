@@ -0,0 +1,185 @@
+package aimux
+
+import (
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "architect", true},
+		{"-", "", true},
+		{"-", "architect", false},
+		{"*", "", false},
+		{"*", "claude", true},
+		{"arch*", "architect", true},
+		{"arch*", "engineer", false},
+		{"codex", "codex", true},
+		{"codex", "claude", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTag(t *testing.T) {
+	tests := []struct {
+		tag     string
+		wantMod string
+		wantGen string
+	}{
+		{"", "", ""},
+		{"claude", "", "claude"},
+		{"architect~claude", "architect", "claude"},
+		{"~claude", "", "claude"},
+	}
+
+	for _, tt := range tests {
+		mod, gen := splitTag(tt.tag)
+		if mod != tt.wantMod || gen != tt.wantGen {
+			t.Errorf("splitTag(%q) = (%q, %q), want (%q, %q)", tt.tag, mod, gen, tt.wantMod, tt.wantGen)
+		}
+	}
+}
+
+// TestCheckPolicyCustomRules verifies ordered evaluation of user-defined rules
+func TestCheckPolicyCustomRules(t *testing.T) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatalf("DefaultConfig() failed: %v", err)
+	}
+
+	// Customer may never call codex, except for a designated escape hatch level
+	zero := 0
+	cfg.Policy.Rules = append([]PolicyRule{
+		{
+			Name:   "customer-codex-top-level",
+			Action: "allow",
+			Top:    "customer",
+			Gen:    "codex",
+			MaxLvl: &zero,
+		},
+		{
+			Name:    "no-customer-codex",
+			Action:  "deny",
+			Top:     "customer",
+			Gen:     "codex",
+			Code:    7,
+			Message: "you ({{top}}) cannot call {{gen}}",
+		},
+	}, cfg.Policy.Rules...)
+
+	tests := []struct {
+		name      string
+		ctx       *Context
+		wantCode  int
+		wantError bool
+		wantMsg   string
+	}{
+		{
+			name:      "customer to codex denied",
+			ctx:       &Context{LVL: 1, TOP: "customer~claude", GEN: "codex", MOD: "architect", TAG: "architect~codex"},
+			wantError: true,
+			wantCode:  7,
+			wantMsg:   "you (Customer Claude) cannot call codex",
+		},
+		{
+			name: "allow rule short-circuits later denies",
+			ctx:  &Context{LVL: 0, TOP: "customer~claude", GEN: "codex", MOD: "engineer", TAG: "engineer~codex"},
+		},
+		{
+			name: "customer to claude architect still allowed",
+			ctx:  &Context{LVL: 1, TOP: "customer~claude", GEN: "claude", MOD: "architect", TAG: "architect~claude"},
+		},
+		{
+			name:      "default rules still apply",
+			ctx:       &Context{LVL: 1, TOP: "engineer~claude", GEN: "claude", MOD: "architect", TAG: "architect~claude"},
+			wantError: true,
+			wantCode:  4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := cfg.CheckPolicy(tt.ctx)
+			if !tt.wantError {
+				if err != nil {
+					t.Errorf("CheckPolicy() error = %v, want nil", err)
+				}
+				return
+			}
+			blockErr, ok := err.(*BlockingError)
+			if !ok {
+				t.Fatalf("CheckPolicy() error = %v, want BlockingError", err)
+			}
+			if blockErr.Code != tt.wantCode {
+				t.Errorf("BlockingError.Code = %v, want %v", blockErr.Code, tt.wantCode)
+			}
+			if tt.wantMsg != "" && blockErr.Message != tt.wantMsg {
+				t.Errorf("BlockingError.Message = %q, want %q", blockErr.Message, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestCheckPolicyUnknownAction(t *testing.T) {
+	cfg := &Config{Policy: PolicyConfig{Rules: []PolicyRule{{Name: "bad", Action: "maybe"}}}}
+	err := cfg.CheckPolicy(&Context{GEN: "claude"})
+	if err == nil {
+		t.Fatal("CheckPolicy() should fail on unknown action")
+	}
+	if _, ok := err.(*BlockingError); ok {
+		t.Error("unknown action should not be reported as a BlockingError")
+	}
+}
+
+// TestCheckPolicyDepthLimits verifies the depth rule honors configured limits
+func TestCheckPolicyDepthLimits(t *testing.T) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatalf("DefaultConfig() failed: %v", err)
+	}
+
+	architect := cfg.Personas["architect"]
+	architect.MaxDepth = 4
+	cfg.Personas["architect"] = architect
+
+	bash := cfg.Genera["bash"]
+	bash.MaxDepth = 1
+	cfg.Genera["bash"] = bash
+
+	tests := []struct {
+		name      string
+		ctx       *Context
+		wantError bool
+	}{
+		{"architect at depth 3 allowed", &Context{LVL: 3, TOP: "customer~claude", GEN: "claude", MOD: "architect", TAG: "architect~claude"}, false},
+		{"architect at depth 4 blocked", &Context{LVL: 4, TOP: "customer~claude", GEN: "claude", MOD: "architect", TAG: "architect~claude"}, true},
+		{"undifferentiated claude at depth 3 blocked", &Context{LVL: 3, TOP: "", GEN: "claude", TAG: "claude"}, true},
+		{"bash at depth 0 allowed", &Context{LVL: 0, GEN: "bash", TAG: "bash"}, false},
+		{"bash at depth 1 blocked", &Context{LVL: 1, TOP: "claude", GEN: "bash", TAG: "bash"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := cfg.CheckPolicy(tt.ctx)
+			if !tt.wantError {
+				if err != nil {
+					t.Errorf("CheckPolicy() error = %v, want nil", err)
+				}
+				return
+			}
+			blockErr, ok := err.(*BlockingError)
+			if !ok || blockErr.Code != 3 {
+				t.Errorf("CheckPolicy() error = %v, want depth BlockingError (code 3)", err)
+			}
+		})
+	}
+}
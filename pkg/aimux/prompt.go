@@ -0,0 +1,260 @@
+package aimux
+
+// prompt.go - Logging user prompts, including prompts streamed on stdin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// defaultStdinCap is the stdin prompt size logged inline when config sets none
+	defaultStdinCap = 64 * 1024
+
+	// stdinDir holds sidecar files for stdin prompts larger than the cap (under Dir0)
+	stdinDir = "stdin"
+
+	// stdinTagPrefix marks the Message.Tags entry naming a prompt's sidecar file
+	stdinTagPrefix = "stdin:"
+
+	// stdinPlaceholder stands in for stdin in the prompt logged before the call
+	stdinPlaceholder = "<<STDIN"
+)
+
+// promptRecorder tees the bytes a genus reads from stdin. The first limit
+// bytes are kept in memory; once exceeded, everything read so far and from
+// then on is spilled to a sidecar file so the full prompt is preserved, and
+// the memory buffer remains as a preview.
+type promptRecorder struct {
+	r       io.Reader
+	limit   int
+	path    string // Sidecar file, created on first spill
+	buf     bytes.Buffer
+	total   int64
+	sidecar *os.File
+	err     error // First sidecar error; recording stops, reading continues
+}
+
+func newPromptRecorder(r io.Reader, limit int, path string) *promptRecorder {
+	return &promptRecorder{r: r, limit: limit, path: path}
+}
+
+func (pr *promptRecorder) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.record(p[:n])
+	}
+	return n, err
+}
+
+// record appends data to the in-memory buffer, spilling to the sidecar file
+// once the buffer would exceed the limit.
+func (pr *promptRecorder) record(data []byte) {
+	pr.total += int64(len(data))
+	if pr.err != nil {
+		return
+	}
+	if pr.sidecar == nil && pr.buf.Len()+len(data) <= pr.limit {
+		pr.buf.Write(data)
+		return
+	}
+	if pr.sidecar == nil {
+		if err := os.MkdirAll(filepath.Dir(pr.path), 0o755); err != nil {
+			pr.err = err
+			return
+		}
+		f, err := os.OpenFile(pr.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+		if err != nil {
+			pr.err = err
+			return
+		}
+		pr.sidecar = f
+		if _, err := f.Write(pr.buf.Bytes()); err != nil {
+			pr.err = err
+			return
+		}
+	}
+	if _, err := pr.sidecar.Write(data); err != nil {
+		pr.err = err
+	}
+	// Keep the buffer filled up to the limit as an inline preview
+	if room := pr.limit - pr.buf.Len(); room > 0 {
+		if room > len(data) {
+			room = len(data)
+		}
+		pr.buf.Write(data[:room])
+	}
+}
+
+// Close closes the sidecar file, if any.
+func (pr *promptRecorder) Close() error {
+	if pr.sidecar == nil {
+		return nil
+	}
+	err := pr.sidecar.Close()
+	pr.sidecar = nil
+	return err
+}
+
+// Message builds the complete user message: cmdArgs followed by the stdin
+// actually consumed (joined the way CallGenus joins them). Stdin beyond the
+// limit is cut short with a pointer to the sidecar file, which is also named
+// in Tags.
+func (pr *promptRecorder) Message(base Message, cmdArgs string) Message {
+	body := pr.buf.String()
+	if pr.total > int64(pr.buf.Len()) {
+		body = trimPartialRune(body)
+		if pr.err != nil {
+			Warn("Failed to spill stdin prompt to %s: %v", pr.path, pr.err)
+			body += fmt.Sprintf("\n[stdin truncated: %d bytes total]", pr.total)
+		} else {
+			body += fmt.Sprintf("\n[stdin truncated: %d bytes total, full input in %s]", pr.total, pr.path)
+			base.Tags = append(base.Tags, stdinTagPrefix+pr.path)
+		}
+	}
+	if cmdArgs != "" {
+		body = cmdArgs + "\n\n" + body
+	}
+	base.Body = body
+	return base
+}
+
+// fullPrompt returns the prompt logged as msg, with stdin spilled to a
+// sidecar file (see promptRecorder.Message) read back in full in place of
+// its preview. It returns msg.Body if the sidecar cannot be read.
+func fullPrompt(msg Message) string {
+	path := tagValue(msg.Tags, stdinTagPrefix)
+	if path == "" {
+		return msg.Body
+	}
+	marker := strings.LastIndex(msg.Body, "\n[stdin truncated: ")
+	if marker < 0 {
+		return msg.Body
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		Warn("Cannot read stdin prompt: %v", err)
+		return msg.Body
+	}
+	stdin := string(data)
+
+	// The preview is a prefix of stdin, after cmdArgs and a blank line if any
+	head := msg.Body[:marker]
+	if strings.HasPrefix(stdin, head) {
+		return stdin
+	}
+	for i := strings.Index(head, "\n\n"); i >= 0; {
+		if strings.HasPrefix(stdin, head[i+2:]) {
+			return head[:i+2] + stdin
+		}
+		next := strings.Index(head[i+1:], "\n\n")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return msg.Body
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence cut off at the end of s.
+func trimPartialRune(s string) string {
+	for i := 0; i < utf8.UTFMax-1 && s != ""; i++ {
+		if r, size := utf8.DecodeLastRuneInString(s); r != utf8.RuneError || size != 1 {
+			break
+		}
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// stdinCap returns the configured inline size limit for stdin prompts,
+// falling back to the built-in default.
+func stdinCap() int {
+	cfg, err := LoadConfig()
+	if err != nil || cfg.StdinCap <= 0 {
+		return defaultStdinCap
+	}
+	return cfg.StdinCap
+}
+
+// loggedPrompt returns the prompt logged before a call: cmdArgs, marked with
+// stdinPlaceholder when stdin follows.
+func loggedPrompt(cmdArgs string, hasStdin bool) string {
+	switch {
+	case !hasStdin:
+		return cmdArgs
+	case cmdArgs == "":
+		return stdinPlaceholder
+	default:
+		return cmdArgs + " " + stdinPlaceholder
+	}
+}
+
+// appendMessage writes msg to Log3.
+func appendMessage(c *Context, msg Message) error {
+	log3, err := Log3(c)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// Ensure log directory exists
+	if err := os.MkdirAll(filepath.Dir(log3), 0o755); err != nil {
+		return err
+	}
+
+	return appendLocked(log3, append(line, '\n'))
+}
+
+// logPrompt logs the user prompt before a call. When stdin is present it
+// returns a reader that records what the genus consumes and a function that,
+// after the call, logs the prompt again with the complete input. Readers put
+// it in place of the first (see ReadMessages), so the log is only appended to.
+func logPrompt(c *Context, callID ID, cmdArgs string, stdin io.Reader) (io.Reader, func()) {
+	msg := Message{
+		SessionID: c.SID,
+		At:        time.Now(),
+		From:      "user",
+		Body:      loggedPrompt(cmdArgs, stdin != nil),
+		Tags:      []string{callTagPrefix + string(callID)},
+	}
+	if err := appendMessage(c, msg); err != nil {
+		Warn("Failed to log user message: %v", err)
+	}
+	if stdin == nil {
+		return nil, func() {}
+	}
+
+	dir0, err := Dir0(c)
+	if err != nil {
+		Warn("Not capturing stdin prompt: %v", err)
+		return stdin, func() {}
+	}
+	rec := newPromptRecorder(stdin, stdinCap(), filepath.Join(dir0, stdinDir, string(callID)+".txt"))
+	return rec, func() {
+		if err := rec.Close(); err != nil {
+			Warn("Failed to close stdin sidecar: %v", err)
+		}
+		if rec.total == 0 {
+			return // Nothing consumed; the placeholder stands
+		}
+		// Logged under the session the call ended in, as the log's last
+		// entry (see lastSessionID)
+		full := rec.Message(msg, cmdArgs)
+		full.SessionID = c.SID
+		if err := appendMessage(c, full); err != nil {
+			Warn("Failed to log user message: %v", err)
+		}
+	}
+}
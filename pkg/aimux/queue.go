@@ -0,0 +1,346 @@
+package aimux
+
+// queue.go - Queued partner calls: sent now, run by a worker, collected later
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const (
+	// queueDir holds a conversation's queued calls, one ticket file each (under Dir0)
+	queueDir = "queue"
+
+	// ticketExt is the extension of ticket files
+	ticketExt = ".json"
+)
+
+// Ticket states, in order.
+const (
+	TicketQueued  = "queued"
+	TicketRunning = "running"
+	TicketDone    = "done"
+	TicketFailed  = "failed"
+)
+
+// errTicketTaken reports a ticket claimed by another worker first.
+var errTicketTaken = errors.New("ticket taken")
+
+// Ticket is a queued call, stored in Dir0/queue/<id>.json. Top, LVL,
+// Parent, Dir and Env are the sender's, so the worker makes the call as the
+// sender would have: validated, logged and recorded in calls.jsonl with the
+// same caller, and run in the same directory and environment. The outcome
+// fields are set once the call ends. Ticket files are only readable by
+// their owner, as Env may hold API keys.
+type Ticket struct {
+	ID       ID        `json:"id"`
+	CID      ID        `json:"cid"`
+	GEN      string    `json:"gen"`
+	MOD      string    `json:"mod"`
+	Top      string    `json:"top"`
+	LVL      int       `json:"lvl"`
+	Parent   ID        `json:"parent,omitempty"`
+	Prompt   string    `json:"prompt"`
+	Stdin    []byte    `json:"stdin"` // Null without stdin
+	Sys      string    `json:"sys,omitempty"`
+	Dir      string    `json:"dir,omitempty"`
+	Env      []string  `json:"env,omitempty"`
+	State    string    `json:"state"`
+	Worker   int       `json:"worker,omitempty"` // PID of the worker running the call
+	Enqueued time.Time `json:"enqueued"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Call     ID        `json:"call,omitempty"` // The CallRecord of the call
+	Exit     int       `json:"exit"`
+	Blocked  bool      `json:"blocked,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Output   []byte    `json:"output"`
+}
+
+// Done returns true once the call has ended, successfully or not.
+func (t *Ticket) Done() bool {
+	return t.State == TicketDone || t.State == TicketFailed
+}
+
+// Callee returns the callee's signature (e.g. "Architect Claude").
+func (t *Ticket) Callee() string {
+	return SigTag(&Context{GEN: t.GEN, MOD: t.MOD})
+}
+
+// queuePath returns Dir0/queue for conversation cid.
+func queuePath(cid ID) (string, error) {
+	dir0, err := Dir0(&Context{CID: cid})
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir0, queueDir), nil
+}
+
+// Send queues the call of cmdArgs (and stdin, read now) to c for a worker
+// and returns its ticket without waiting for it. The call is validated here
+// too, so a blocked call fails now rather than in the worker.
+func Send(c *Context, cmdArgs string, stdin io.Reader) (*Ticket, error) {
+	if err := ValidateCall(c); err != nil {
+		return nil, err
+	}
+	id, err := NewID()
+	if err != nil {
+		return nil, err
+	}
+	t := &Ticket{
+		ID:       id,
+		CID:      c.CID,
+		GEN:      c.GEN,
+		MOD:      c.MOD,
+		Top:      c.TOP,
+		LVL:      c.LVL,
+		Parent:   ID(os.Getenv(callEnvVar)),
+		Prompt:   cmdArgs,
+		Sys:      c.ENV["AISYS"],
+		State:    TicketQueued,
+		Enqueued: time.Now().UTC(),
+	}
+	t.Dir, t.Env = callerProcess()
+	if t.Stdin, err = readStdin(stdin); err != nil {
+		return nil, err
+	}
+
+	dir, err := queuePath(c.CID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := writeTicket(filepath.Join(dir, string(id)+ticketExt), t); err != nil {
+		return nil, fmt.Errorf("write ticket: %w", err)
+	}
+	return t, nil
+}
+
+// writeTicket saves t to the ticket file at path.
+func writeTicket(path string, t *Ticket) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomicPerm(path, append(data, '\n'), 0o600)
+}
+
+// LoadTicket reads ticket id, from whichever conversation queued it.
+func LoadTicket(id ID) (*Ticket, error) {
+	path, err := findTicket(id)
+	if err != nil {
+		return nil, err
+	}
+	return readTicket(path)
+}
+
+// findTicket returns the path of ticket id's file.
+func findTicket(id ID) (string, error) {
+	if !isValidUUID(string(id)) {
+		return "", fmt.Errorf("invalid ticket %q", id)
+	}
+	home, err := homeDir()
+	if err != nil {
+		return "", err
+	}
+	matches, err := filepath.Glob(filepath.Join(home, aimuxDir, conversationsDir, "*", queueDir, string(id)+ticketExt))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("ticket %s not found", id)
+	}
+	return matches[0], nil
+}
+
+// readTicket reads the ticket file at path.
+func readTicket(path string) (*Ticket, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Ticket
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("parse ticket %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// updateTicket applies fn to the ticket at path under its lock and saves
+// the result, unless fn fails.
+func updateTicket(path string, fn func(t *Ticket) error) (*Ticket, error) {
+	var t *Ticket
+	err := withFileLock(path, func() error {
+		var err error
+		if t, err = readTicket(path); err != nil {
+			return err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+		return writeTicket(path, t)
+	})
+	return t, err
+}
+
+// claimable returns true if a worker may take t: it is queued, or was left
+// running by a worker that has since exited.
+func claimable(t *Ticket) bool {
+	return t.State == TicketQueued || t.State == TicketRunning && !processAlive(t.Worker)
+}
+
+// processAlive returns true if process pid exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// ClaimTicket marks the oldest claimable ticket of conversation cid (of
+// every conversation if cid is empty) as running in this process and
+// returns it, or returns nil if there is none. Workers may claim
+// concurrently; each ticket is claimed once, and claimed again only if its
+// worker exits without finishing it.
+func ClaimTicket(cid ID) (*Ticket, error) {
+	cids := []ID{cid}
+	if cid == "" {
+		var err error
+		if cids, err = conversationIDs(); err != nil {
+			return nil, err
+		}
+	}
+
+	type candidate struct {
+		path     string
+		enqueued time.Time
+	}
+	var candidates []candidate
+	for _, cid := range cids {
+		dir, err := queuePath(cid)
+		if err != nil {
+			return nil, err
+		}
+		paths, err := filepath.Glob(filepath.Join(dir, "*"+ticketExt))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			t, err := readTicket(path)
+			if err != nil {
+				Warn("Skipping ticket: %v", err)
+				continue
+			}
+			if claimable(t) {
+				candidates = append(candidates, candidate{path, t.Enqueued})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].enqueued.Before(candidates[j].enqueued)
+	})
+
+	for _, cand := range candidates {
+		t, err := updateTicket(cand.path, func(t *Ticket) error {
+			if !claimable(t) {
+				return errTicketTaken
+			}
+			t.State = TicketRunning
+			t.Worker = os.Getpid()
+			t.Started = time.Now().UTC()
+			return nil
+		})
+		if errors.Is(err, errTicketTaken) {
+			continue
+		}
+		return t, err
+	}
+	return nil, nil
+}
+
+// RunTicket makes the call of claimed ticket t with RunCall, as t's sender,
+// and saves its outcome in t. The returned error is about the ticket itself;
+// the call's error is in t.Error.
+func RunTicket(ctx context.Context, t *Ticket) error {
+	var out bytes.Buffer
+	rec, err := runTicketCall(ctx, t, &out)
+
+	path, perr := findTicket(t.ID)
+	if perr != nil {
+		return perr
+	}
+	saved, perr := updateTicket(path, func(saved *Ticket) error {
+		saved.State = TicketDone
+		saved.Finished = time.Now().UTC()
+		saved.Output = out.Bytes()
+		saved.Exit = ExitCode(err)
+		if rec != nil {
+			saved.Call = rec.ID
+		}
+		if err != nil {
+			var blockErr *BlockingError
+			saved.State = TicketFailed
+			saved.Blocked = errors.As(err, &blockErr)
+			saved.Error = err.Error()
+		}
+		return nil
+	})
+	if perr != nil {
+		return perr
+	}
+	*t = *saved
+	return nil
+}
+
+// runTicketCall rebuilds the context t was sent from and makes the call.
+func runTicketCall(ctx context.Context, t *Ticket, w io.Writer) (*CallRecord, error) {
+	c, err := JoinContext(t.CID, t.GEN, t.MOD)
+	if err != nil {
+		return nil, err
+	}
+	c.TOP, c.LVL = t.Top, t.LVL
+	c.workDir, c.environ = t.Dir, t.Env
+	if t.Sys != "" {
+		c.ENV["AISYS"] = t.Sys
+	}
+	for k, v := range InferFlowHints(t.Prompt) {
+		c.ENV["AI"+k] = v
+	}
+	id, err := NewID()
+	if err != nil {
+		return nil, err
+	}
+	return runCall(ctx, c, id, t.Parent, t.Prompt, stdinReader(t.Stdin), w)
+}
+
+// WaitTicket polls ticket id every poll until its call has ended or ctx is
+// done, and returns it.
+func WaitTicket(ctx context.Context, id ID, poll time.Duration) (*Ticket, error) {
+	path, err := findTicket(id)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, err := readTicket(path)
+		if err != nil || t.Done() {
+			return t, err
+		}
+		select {
+		case <-ctx.Done():
+			return t, ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package aimux
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	cid, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sent by the architect's call, to its engineer
+	t.Setenv("AITAG", "architect~claude")
+	t.Setenv("AILVL", "1")
+	t.Setenv("AICALL", string(parent))
+	c, err := JoinContext(cid, "bash", "engineer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, err := Send(c, "cat; echo $AIMOD", strings.NewReader("hello\n"))
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	failing, err := Send(c, "echo partial; exit 3", nil)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	c.LVL = 3
+	var blockErr *BlockingError
+	if _, err := Send(c, "echo too deep", nil); !errors.As(err, &blockErr) {
+		t.Errorf("Send() error = %v, want blocked", err)
+	}
+
+	// Nothing runs until a worker claims the ticket
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	t.Setenv("AICALL", "")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if got, err := WaitTicket(ctx, sent.ID, 5*time.Millisecond); err != context.DeadlineExceeded || got.State != TicketQueued {
+		t.Fatalf("WaitTicket() = %+v, %v, want queued", got, err)
+	}
+
+	claimed, err := ClaimTicket("")
+	if err != nil || claimed == nil || claimed.ID != sent.ID || claimed.State != TicketRunning {
+		t.Fatalf("ClaimTicket() = %+v, %v, want %s running", claimed, err, sent.ID)
+	}
+	if err := RunTicket(context.Background(), claimed); err != nil {
+		t.Fatalf("RunTicket() error = %v", err)
+	}
+	got, err := WaitTicket(context.Background(), sent.ID, time.Millisecond)
+	if err != nil || got.State != TicketDone || string(got.Output) != "hello\nengineer\n" || got.Call == "" {
+		t.Errorf("WaitTicket() = %+v, %v", got, err)
+	}
+
+	// The call is recorded as the sender's
+	records, err := LoadCalls(cid)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("LoadCalls() = %+v, %v", records, err)
+	}
+	if rec := records[0]; rec.ID != got.Call || rec.Top != "architect~claude" || rec.LVL != 1 || rec.Parent != parent {
+		t.Errorf("record = %+v", rec)
+	}
+
+	// A ticket left running by a worker that exited is claimed again
+	dead := exec.Command("true")
+	if err := dead.Run(); err != nil {
+		t.Fatal(err)
+	}
+	path, err := findTicket(failing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updateTicket(path, func(t *Ticket) error {
+		t.State, t.Worker = TicketRunning, dead.Process.Pid
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = ClaimTicket(cid)
+	if err != nil || claimed == nil || claimed.ID != failing.ID || claimed.Worker != os.Getpid() {
+		t.Fatalf("ClaimTicket() = %+v, %v, want %s reclaimed", claimed, err, failing.ID)
+	}
+	if next, err := ClaimTicket(cid); next != nil || err != nil {
+		t.Errorf("ClaimTicket() = %+v, %v, want none left", next, err)
+	}
+	if err := RunTicket(context.Background(), claimed); err != nil {
+		t.Fatalf("RunTicket() error = %v", err)
+	}
+	if claimed.State != TicketFailed || claimed.Exit != 3 || string(claimed.Output) != "partial\n" || claimed.Error == "" {
+		t.Errorf("failed ticket = %+v", claimed)
+	}
+
+	// The call runs in the sender's directory and environment, and binary
+	// stdin and output survive the ticket file
+	work := filepath.Join(tmpDir, "work")
+	if err := os.Mkdir(work, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	wd := mustGetwd(t)
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SENDER_VAR", "sender")
+	c.LVL = 1
+	sent, err = Send(c, `cat; pwd; echo "$SENDER_VAR"`, strings.NewReader("\xff\xfe\n"))
+	os.Chdir(wd)
+	os.Unsetenv("SENDER_VAR")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if path, err = findTicket(sent.ID); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("ticket file mode = %v, %v, want 0600", info.Mode(), err)
+	}
+	if claimed, err = ClaimTicket(cid); err != nil || claimed == nil {
+		t.Fatalf("ClaimTicket() = %+v, %v", claimed, err)
+	}
+	if err := RunTicket(context.Background(), claimed); err != nil {
+		t.Fatalf("RunTicket() error = %v", err)
+	}
+	if want := "\xff\xfe\n" + work + "\nsender\n"; claimed.State != TicketDone || string(claimed.Output) != want {
+		t.Errorf("ticket output = %q (%s), want %q", claimed.Output, claimed.State, want)
+	}
+}
@@ -0,0 +1,123 @@
+package aimux
+
+// retry.go - Retrying genus calls that fail transiently
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = 30 * time.Second
+)
+
+// RetryPolicy says when a failed genus call is attempted again. A failure is
+// retryable if nothing was output yet and its error message contains one of
+// Messages (case-insensitively), the genus exited with one of ExitCodes, or
+// an HTTP genus answered with one of Statuses.
+// The wait before retry n (1-based) is Backoff doubled n-1 times, capped at
+// MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts int      `json:"max_attempts"`          // Attempts in total, including the first
+	Backoff     string   `json:"backoff,omitempty"`     // Wait before the first retry (default 1s)
+	MaxBackoff  string   `json:"max_backoff,omitempty"` // Longest wait (default 30s)
+	Messages    []string `json:"messages,omitempty"`
+	ExitCodes   []int    `json:"exit_codes,omitempty"`
+	Statuses    []int    `json:"statuses,omitempty"`
+}
+
+// retrier applies a genus's RetryPolicy to the attempts of one call.
+type retrier struct {
+	policy     RetryPolicy
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// newRetrier parses the retry policy of c's genus. A genus without one is
+// attempted once.
+func newRetrier(c *Context) (*retrier, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	r := &retrier{backoff: defaultRetryBackoff, maxBackoff: defaultRetryMaxBackoff}
+	genus, ok := cfg.GetGenus(c.GEN)
+	if !ok || genus.Retry == nil {
+		return r, nil
+	}
+	r.policy = *genus.Retry
+	if r.policy.Backoff != "" {
+		if r.backoff, err = time.ParseDuration(r.policy.Backoff); err != nil || r.backoff < 0 {
+			return nil, fmt.Errorf("genus %s: invalid retry backoff %q", c.GEN, r.policy.Backoff)
+		}
+	}
+	if r.policy.MaxBackoff != "" {
+		if r.maxBackoff, err = time.ParseDuration(r.policy.MaxBackoff); err != nil || r.maxBackoff < 0 {
+			return nil, fmt.Errorf("genus %s: invalid retry max_backoff %q", c.GEN, r.policy.MaxBackoff)
+		}
+	}
+	return r, nil
+}
+
+// canRetry reports whether attempt (1-based) may be followed by another.
+func (r *retrier) canRetry(attempt int) bool {
+	return attempt < r.policy.MaxAttempts
+}
+
+// retryable reports whether a failure with message msg and exit status exit
+// may be retried. BlockingErrors never are: retrying cannot unblock a call.
+func (r *retrier) retryable(err error, msg string, exit int) bool {
+	var blockErr *BlockingError
+	if errors.As(err, &blockErr) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	for _, code := range r.policy.ExitCodes {
+		if exit != 0 && code == exit {
+			return true
+		}
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		for _, status := range r.policy.Statuses {
+			if status == statusErr.StatusCode {
+				return true
+			}
+		}
+	}
+	msg = strings.ToLower(msg)
+	for _, m := range r.policy.Messages {
+		if m != "" && strings.Contains(msg, strings.ToLower(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the wait before retrying attempt.
+func (r *retrier) delay(attempt int) time.Duration {
+	delay := r.backoff
+	for i := 1; i < attempt && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	return delay
+}
+
+// wait sleeps before retrying attempt, returning early with ctx's error if it
+// is done first.
+func (r *retrier) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(r.delay(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
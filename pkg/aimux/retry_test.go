@@ -0,0 +1,151 @@
+package aimux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	r := &retrier{backoff: time.Second, maxBackoff: 5 * time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{40, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := r.delay(tt.attempt); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	r := &retrier{policy: RetryPolicy{MaxAttempts: 3, Messages: []string{"Rate Limit"}, ExitCodes: []int{75}, Statuses: []int{529}}}
+	tests := []struct {
+		name string
+		err  error
+		msg  string
+		exit int
+		want bool
+	}{
+		{"message", nil, "429: rate limit exceeded", 0, true},
+		{"exit code", fmt.Errorf("exit status 75"), "exit status 75", 75, true},
+		{"other failure", fmt.Errorf("exit status 1"), "exit status 1", 1, false},
+		{"status", fmt.Errorf("stream: %w", &HTTPStatusError{StatusCode: 529, Status: "529"}), "stream: HTTP 529", 1, true},
+		{"status in message", &HTTPStatusError{StatusCode: 400, Status: "400 Bad Request", Message: "max_tokens > 529"}, "HTTP 400 Bad Request: max_tokens > 529", 1, false},
+		{"blocked", &BlockingError{Code: 75, Message: "rate limit"}, "rate limit", 75, false},
+		{"canceled", fmt.Errorf("stream: %w", context.Canceled), "rate limit", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.retryable(tt.err, tt.msg, tt.exit); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunCallRetry(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+	t.Setenv("AITAG", "")
+	t.Setenv("AILVL", "")
+	t.Setenv("AICALL", "")
+
+	// The first two requests are rate limited
+	server := &chatTestServer{}
+	server.reply = func(w http.ResponseWriter, body map[string]interface{}) {
+		if len(server.requests) <= 2 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"finally\"}}]}\n\n")
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	retry := &RetryPolicy{MaxAttempts: 3, Backoff: "1ms", Statuses: []int{429}, ExitCodes: []int{75}}
+	cfg, err := DefaultConfig()
+	if err != nil {
+		t.Fatalf("DefaultConfig() error = %v", err)
+	}
+	bash := cfg.Genera["bash"]
+	bash.Retry = retry
+	data, err := json.Marshal(map[string]interface{}{"genera": map[string]GenusConfig{
+		"compat": {
+			Name:     "compat",
+			Type:     GenusTypeOpenAI,
+			URL:      ts.URL,
+			Format:   StreamFormatOpenAI,
+			Personas: map[string]PersonaVars{"": {"model": "m"}},
+			Retry:    retry,
+		},
+		"bash": bash,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLog(t, filepath.Join(tmpDir, ".aimux", "config.json"), string(data))
+
+	ctx, err := InitContext("compat", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+	var out bytes.Buffer
+	rec, err := RunCall(context.Background(), ctx, "ask", strings.NewReader("piped"), &out)
+	if err != nil {
+		t.Fatalf("RunCall() error = %v", err)
+	}
+	if out.String() != "finally\n" || rec.Attempts != 3 {
+		t.Errorf("RunCall() output = %q after %d attempts, want \"finally\" after 3", out.String(), rec.Attempts)
+	}
+	for i := range server.requests {
+		if got := server.roles(t, i); !strings.HasSuffix(got, "|user:ask\n\npiped") {
+			t.Errorf("request %d messages = %s, want the replayed prompt", i+1, got)
+		}
+	}
+	// Retries do not duplicate the prompt
+	messages, err := Transcript(ctx.CID, TranscriptFilter{})
+	if err != nil || len(messages) != 2 {
+		t.Errorf("Transcript() = %+v, %v, want one prompt and one reply", messages, err)
+	}
+
+	// Retryable exit statuses
+	shell, err := InitContext("bash", "")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+	counter := filepath.Join(tmpDir, "counter")
+	script := fmt.Sprintf(`echo x >> %s; [ $(wc -l < %s) -ge 2 ] || exit 75; echo ok`, counter, counter)
+	out.Reset()
+	if rec, err = RunCall(context.Background(), shell, script, nil, &out); err != nil || rec.Attempts != 2 || out.String() != "ok\n" {
+		t.Errorf("RunCall(exit 75) = %v, %d attempts, output %q", err, rec.Attempts, out.String())
+	}
+
+	// Failures after output, other exit statuses and blocked calls are not retried
+	for _, cmd := range []string{"echo partial; exit 75", "exit 1"} {
+		if rec, err = RunCall(context.Background(), shell, cmd, nil, &out); err == nil || rec.Attempts != 0 {
+			t.Errorf("RunCall(%q) = %v after %d attempts, want one failed attempt", cmd, err, rec.Attempts)
+		}
+	}
+	shell.LVL = 3
+	if rec, err = RunCall(context.Background(), shell, "echo blocked", nil, &out); ExitCode(err) != 3 || rec.Attempts != 0 {
+		t.Errorf("RunCall(blocked) = %v after %d attempts, want blocked once", err, rec.Attempts)
+	}
+}
@@ -0,0 +1,123 @@
+package aimux
+
+// transcript.go - Conversation transcript filtering and rendering
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Transcript output formats accepted by RenderTranscript.
+const (
+	FormatMarkdown = "markdown"
+	FormatPlain    = "plain"
+	FormatJSON     = "json"
+)
+
+// TranscriptFilter narrows a conversation transcript. GEN and MOD use the
+// call-policy pattern syntax (see matchPattern): empty matches anything and
+// "-" selects undifferentiated sessions. Zero times leave the window open.
+type TranscriptFilter struct {
+	GEN   string
+	MOD   string
+	SID   ID
+	Since time.Time
+	Until time.Time
+}
+
+// TranscriptMessage is a transcript entry with the session it was logged by.
+type TranscriptMessage struct {
+	Message
+	GEN string `json:"gen"`
+	MOD string `json:"mod"`
+}
+
+// Transcript reconstructs a conversation transcript: user prompts logged by
+// AppendMessage interleaved with assistant output logged by StreamAndLog,
+// across every genus and persona, in chronological order.
+func Transcript(cid ID, f TranscriptFilter) ([]TranscriptMessage, error) {
+	messages, err := loadConversationMessages(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []TranscriptMessage
+	for _, msg := range messages {
+		mod, gen := splitTag(messageTag(msg))
+		if !matchPattern(f.GEN, gen) || !matchPattern(f.MOD, mod) {
+			continue
+		}
+		if f.SID != "" && NormalizeUUID(string(msg.SessionID)) != NormalizeUUID(string(f.SID)) {
+			continue
+		}
+		if !f.Since.IsZero() && msg.At.Before(f.Since) {
+			continue
+		}
+		if !f.Until.IsZero() && msg.At.After(f.Until) {
+			continue
+		}
+		out = append(out, TranscriptMessage{Message: msg, GEN: gen, MOD: mod})
+	}
+	return out, nil
+}
+
+// RenderTranscript writes messages in the given format (markdown, plain or json).
+func RenderTranscript(w io.Writer, messages []TranscriptMessage, format string) error {
+	switch format {
+	case FormatJSON:
+		if messages == nil {
+			messages = []TranscriptMessage{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(messages)
+
+	case FormatPlain:
+		for _, msg := range messages {
+			if _, err := fmt.Fprintf(w, "[%s] %s: %s\n", formatAt(msg.At), speaker(msg), msg.Body); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case FormatMarkdown:
+		for _, msg := range messages {
+			body := msg.Body
+			switch msg.From {
+			case "tool_use", "tool_result":
+				body = "```\n" + strings.TrimRight(body, "\n") + "\n```"
+			case "reasoning":
+				body = "> " + strings.ReplaceAll(strings.TrimRight(body, "\n"), "\n", "\n> ")
+			}
+			if _, err := fmt.Fprintf(w, "### %s\n\n*%s*\n\n%s\n\n", speaker(msg), formatAt(msg.At), body); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown transcript format %q (valid: %s, %s, %s)", format, FormatMarkdown, FormatPlain, FormatJSON)
+}
+
+// speaker describes who produced a message: "User → Architect Claude" for
+// prompts, "Architect Claude" for responses, with other kinds in parentheses.
+func speaker(msg TranscriptMessage) string {
+	sig := formatSig(Tag3(&Context{GEN: msg.GEN, MOD: msg.MOD}))
+	switch msg.From {
+	case "user":
+		return "User → " + sig
+	case "assistant":
+		return sig
+	}
+	return fmt.Sprintf("%s (%s)", sig, msg.From)
+}
+
+// formatAt renders a message timestamp, or "-" if unknown.
+func formatAt(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,103 @@
+package aimux
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTranscript(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	cid := ID("12345678-1234-4123-8234-123456789abc")
+	sid := ID("aaaaaaaa-1234-4123-8234-123456789abc")
+	root := filepath.Join(tmpDir, ".aimux", "conversations", string(cid))
+	base := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	// Prompt from AppendMessage followed by untimestamped stream output
+	writeTestLog(t, filepath.Join(root, "claude", "architect", "log.jsonl"),
+		Message{SessionID: sid, At: base, From: "user", Body: "design it"},
+		`{"type":"assistant","session_id":"`+string(sid)+`","message":{"content":[{"type":"text","text":"here is a design"}]}}`,
+	)
+	writeTestLog(t, filepath.Join(root, "codex", "-", "log.jsonl"),
+		Message{SessionID: cid, At: base.Add(time.Minute), From: "user", Body: "review it"},
+		`{"type":"item.completed","item":{"id":"item_1","type":"agent_message","text":"looks fine"}}`,
+	)
+
+	tests := []struct {
+		name   string
+		filter TranscriptFilter
+		want   []string
+	}{
+		{"all", TranscriptFilter{}, []string{"design it", "here is a design", "review it", "looks fine"}},
+		{"genus", TranscriptFilter{GEN: "codex"}, []string{"review it", "looks fine"}},
+		{"persona", TranscriptFilter{MOD: "architect"}, []string{"design it", "here is a design"}},
+		{"undifferentiated", TranscriptFilter{MOD: "-"}, []string{"review it", "looks fine"}},
+		{"session", TranscriptFilter{SID: ID(strings.ToUpper(string(sid)))}, []string{"design it", "here is a design"}},
+		{"since", TranscriptFilter{Since: base.Add(30 * time.Second)}, []string{"review it", "looks fine"}},
+		{"until", TranscriptFilter{Until: base.Add(30 * time.Second)}, []string{"design it", "here is a design"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := Transcript(cid, tt.filter)
+			if err != nil {
+				t.Fatalf("Transcript() error = %v", err)
+			}
+			var got []string
+			for _, msg := range messages {
+				got = append(got, msg.Body)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Transcript() bodies = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	messages, err := Transcript(cid, TranscriptFilter{})
+	if err != nil {
+		t.Fatalf("Transcript() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := RenderTranscript(&buf, messages, FormatMarkdown); err != nil {
+		t.Fatalf("RenderTranscript(markdown) error = %v", err)
+	}
+	for _, want := range []string{"### User → Architect Claude", "### Architect Claude\n", "here is a design", "### Codex"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("markdown transcript missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := RenderTranscript(&buf, messages, FormatPlain); err != nil {
+		t.Fatalf("RenderTranscript(plain) error = %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 4 ||
+		!strings.HasPrefix(lines[0], "[2026-01-15T10:00:00Z] User → Architect Claude: design it") {
+		t.Errorf("plain transcript = %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := RenderTranscript(&buf, messages, FormatJSON); err != nil {
+		t.Fatalf("RenderTranscript(json) error = %v", err)
+	}
+	var decoded []TranscriptMessage
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("json transcript decode error = %v", err)
+	}
+	if len(decoded) != 4 || decoded[3].GEN != "codex" || decoded[0].MOD != "architect" {
+		t.Errorf("json transcript = %+v", decoded)
+	}
+
+	if err := RenderTranscript(&buf, messages, "html"); err == nil {
+		t.Error("RenderTranscript(html) expected error")
+	}
+}
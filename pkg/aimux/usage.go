@@ -0,0 +1,208 @@
+package aimux
+
+// usage.go - Token and cost accounting from genus result events
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Usage groupings accepted by UsageReport.
+const (
+	UsageByTag          = "tag"
+	UsageByPersona      = "persona"
+	UsageByGenus        = "genus"
+	UsageByConversation = "conversation"
+)
+
+// Usage is the token usage, cost and timing a genus reported for a call
+// (from Claude's stream-json "result" event).
+type Usage struct {
+	InputTokens              int64   `json:"input_tokens"`
+	OutputTokens             int64   `json:"output_tokens"`
+	CacheCreationInputTokens int64   `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int64   `json:"cache_read_input_tokens,omitempty"`
+	CostUSD                  float64 `json:"cost_usd"`
+	DurationMS               int64   `json:"duration_ms,omitempty"`
+	DurationAPIMS            int64   `json:"duration_api_ms,omitempty"`
+	Turns                    int     `json:"turns,omitempty"`
+}
+
+// TotalTokens returns all input (including cache) and output tokens.
+func (u Usage) TotalTokens() int64 {
+	return u.InputTokens + u.OutputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+}
+
+// Add accumulates other into u.
+func (u *Usage) Add(other Usage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CacheCreationInputTokens += other.CacheCreationInputTokens
+	u.CacheReadInputTokens += other.CacheReadInputTokens
+	u.CostUSD += other.CostUSD
+	u.DurationMS += other.DurationMS
+	u.DurationAPIMS += other.DurationAPIMS
+	u.Turns += other.Turns
+}
+
+// String formats usage for the stderr footer, e.g. "$0.0123 / 15.2k tokens".
+func (u Usage) String() string {
+	return fmt.Sprintf("$%.4f / %s tokens", u.CostUSD, FormatTokens(u.TotalTokens()))
+}
+
+// FormatTokens abbreviates a token count (950, 15.2k, 1.3M).
+func FormatTokens(n int64) string {
+	switch {
+	case n >= 1000000:
+		return fmt.Sprintf("%.1fM", float64(n)/1000000)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// resultUsage extracts usage from a result event. Cost is read from
+// total_cost_usd (or the older cost_usd).
+func resultUsage(data map[string]interface{}) *Usage {
+	u := &Usage{
+		DurationMS:    jsonInt(data["duration_ms"]),
+		DurationAPIMS: jsonInt(data["duration_api_ms"]),
+		Turns:         int(jsonInt(data["num_turns"])),
+	}
+	if cost, ok := data["total_cost_usd"].(float64); ok {
+		u.CostUSD = cost
+	} else if cost, ok := data["cost_usd"].(float64); ok {
+		u.CostUSD = cost
+	}
+	if usage, ok := data["usage"].(map[string]interface{}); ok {
+		u.InputTokens = jsonInt(usage["input_tokens"])
+		u.OutputTokens = jsonInt(usage["output_tokens"])
+		u.CacheCreationInputTokens = jsonInt(usage["cache_creation_input_tokens"])
+		u.CacheReadInputTokens = jsonInt(usage["cache_read_input_tokens"])
+	}
+	return u
+}
+
+// jsonInt converts a decoded JSON number to int64 (0 if not a number).
+func jsonInt(v interface{}) int64 {
+	if f, ok := v.(float64); ok {
+		return int64(f)
+	}
+	return 0
+}
+
+// UsageRow is the usage of all calls sharing a UsageReport key.
+type UsageRow struct {
+	Key   string `json:"key"`
+	Calls int    `json:"calls"`
+	Usage
+}
+
+// UsageReport aggregates the usage recorded in calls.jsonl, grouped by tag,
+// persona, genus or conversation, for one conversation (or all if cid is
+// empty) and calls started at or after since (if non-zero). Calls without
+// reported usage count towards Calls only. Rows are sorted by cost, then
+// tokens, highest first.
+func UsageReport(cid ID, by string, since time.Time) ([]UsageRow, error) {
+	key, err := usageKey(by)
+	if err != nil {
+		return nil, err
+	}
+
+	cids := []ID{cid}
+	if cid == "" {
+		if cids, err = conversationIDs(); err != nil {
+			return nil, err
+		}
+	}
+
+	rows := map[string]*UsageRow{}
+	for _, id := range cids {
+		records, err := LoadCalls(id)
+		if err != nil {
+			Warn("Skipping usage of %s: %v", id, err)
+			continue
+		}
+		for _, rec := range records {
+			if !since.IsZero() && rec.Start.Before(since) {
+				continue
+			}
+			k := key(rec)
+			row, ok := rows[k]
+			if !ok {
+				row = &UsageRow{Key: k}
+				rows[k] = row
+			}
+			row.Calls++
+			if rec.Usage != nil {
+				row.Add(*rec.Usage)
+			}
+		}
+	}
+
+	report := make([]UsageRow, 0, len(rows))
+	for _, row := range rows {
+		report = append(report, *row)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].CostUSD != report[j].CostUSD {
+			return report[i].CostUSD > report[j].CostUSD
+		}
+		if report[i].TotalTokens() != report[j].TotalTokens() {
+			return report[i].TotalTokens() > report[j].TotalTokens()
+		}
+		return report[i].Key < report[j].Key
+	})
+	return report, nil
+}
+
+// usageKey returns the function grouping call records for a UsageReport.
+// Undifferentiated calls group under persona "-".
+func usageKey(by string) (func(CallRecord) string, error) {
+	switch by {
+	case UsageByTag:
+		return func(r CallRecord) string { return r.Tag }, nil
+	case UsageByPersona:
+		return func(r CallRecord) string {
+			if mod, _ := splitTag(r.Tag); mod != "" {
+				return mod
+			}
+			return emptyModPlaceholder
+		}, nil
+	case UsageByGenus:
+		return func(r CallRecord) string {
+			_, gen := splitTag(r.Tag)
+			return gen
+		}, nil
+	case UsageByConversation:
+		return func(r CallRecord) string { return string(r.CID) }, nil
+	}
+	return nil, fmt.Errorf("unknown usage grouping %q (valid: %s, %s, %s, %s)",
+		by, UsageByTag, UsageByPersona, UsageByGenus, UsageByConversation)
+}
+
+// conversationIDs lists the IDs of all conversations under ~/.aimux/conversations.
+func conversationIDs() ([]ID, error) {
+	home, err := homeDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(home, aimuxDir, conversationsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read conversations: %w", err)
+	}
+
+	var ids []ID
+	for _, entry := range entries {
+		if entry.IsDir() && isValidUUID(entry.Name()) {
+			ids = append(ids, ID(entry.Name()))
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,110 @@
+package aimux
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamAndLogUsage(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	ctx, err := InitContext("claude", "architect")
+	if err != nil {
+		t.Fatalf("InitContext() error = %v", err)
+	}
+	sid := string(ctx.SID)
+	stream := strings.Join([]string{
+		`{"type":"system","subtype":"init","session_id":"` + sid + `"}`,
+		`{"type":"assistant","session_id":"` + sid + `","message":{"content":[{"type":"text","text":"done\n"}]}}`,
+		`{"type":"result","subtype":"success","is_error":false,"duration_ms":2500,"duration_api_ms":2000,"num_turns":2,"result":"done","session_id":"` + sid + `","total_cost_usd":0.0123,"usage":{"input_tokens":100,"cache_creation_input_tokens":20,"cache_read_input_tokens":1000,"output_tokens":50}}`,
+	}, "\n") + "\n"
+
+	result, err := StreamAndLog(ctx, strings.NewReader(stream), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("StreamAndLog() error = %v", err)
+	}
+	want := Usage{
+		InputTokens:              100,
+		OutputTokens:             50,
+		CacheCreationInputTokens: 20,
+		CacheReadInputTokens:     1000,
+		CostUSD:                  0.0123,
+		DurationMS:               2500,
+		DurationAPIMS:            2000,
+		Turns:                    2,
+	}
+	if result.Usage == nil || *result.Usage != want {
+		t.Errorf("StreamAndLog() usage = %+v, want %+v", result.Usage, want)
+	}
+	if got := result.Usage.String(); got != "$0.0123 / 1.2k tokens" {
+		t.Errorf("Usage.String() = %q", got)
+	}
+	for n, want := range map[int64]string{950: "950", 15200: "15.2k", 1300000: "1.3M"} {
+		if got := FormatTokens(n); got != want {
+			t.Errorf("FormatTokens(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestUsageReport(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", oldHome)
+
+	cid1 := ID("12345678-1234-4123-8234-123456789abc")
+	cid2 := ID("87654321-1234-4123-8234-123456789abc")
+	base := time.Date(2026, 1, 15, 10, 0, 0, 0, time.UTC)
+	root := filepath.Join(tmpDir, ".aimux", "conversations")
+
+	writeTestLog(t, filepath.Join(root, string(cid1), callsFileName),
+		CallRecord{ID: "a", CID: cid1, Tag: "architect~claude", Start: base, Usage: &Usage{InputTokens: 100, OutputTokens: 10, CostUSD: 0.5}},
+		CallRecord{ID: "b", CID: cid1, Tag: "engineer~claude", Start: base, Usage: &Usage{InputTokens: 300, OutputTokens: 30, CostUSD: 0.25}},
+		CallRecord{ID: "c", CID: cid1, Tag: "bash", Start: base},
+	)
+	writeTestLog(t, filepath.Join(root, string(cid2), callsFileName),
+		CallRecord{ID: "d", CID: cid2, Tag: "architect~claude", Start: base.Add(time.Hour), Usage: &Usage{InputTokens: 1000, OutputTokens: 100, CostUSD: 1}},
+	)
+
+	tests := []struct {
+		name  string
+		cid   ID
+		by    string
+		since time.Time
+		want  string
+	}{
+		{"by persona", "", UsageByPersona, time.Time{}, "architect:2:1.5000,engineer:1:0.2500,-:1:0.0000"},
+		{"by genus", "", UsageByGenus, time.Time{}, "claude:3:1.7500,bash:1:0.0000"},
+		{"by tag in conversation", cid1, UsageByTag, time.Time{}, "architect~claude:1:0.5000,engineer~claude:1:0.2500,bash:1:0.0000"},
+		{"by conversation", "", UsageByConversation, time.Time{}, string(cid2) + ":1:1.0000," + string(cid1) + ":3:0.7500"},
+		{"since", "", UsageByPersona, base.Add(time.Minute), "architect:1:1.0000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := UsageReport(tt.cid, tt.by, tt.since)
+			if err != nil {
+				t.Fatalf("UsageReport() error = %v", err)
+			}
+			var rows []string
+			for _, row := range report {
+				rows = append(rows, fmt.Sprintf("%s:%d:%.4f", row.Key, row.Calls, row.CostUSD))
+			}
+			if got := strings.Join(rows, ","); got != tt.want {
+				t.Errorf("UsageReport() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := UsageReport("", "model", time.Time{}); err == nil {
+		t.Error("UsageReport(by model) expected error")
+	}
+}
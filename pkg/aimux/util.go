@@ -24,6 +24,11 @@ func NormalizeUUID(s string) string {
 	return strings.ToLower(s)
 }
 
+// IsValidUUID checks basic UUID format, as for conversation and call IDs
+func IsValidUUID(s string) bool {
+	return isValidUUID(s)
+}
+
 // isValidUUID checks basic UUID format (accepts both uppercase and lowercase)
 func isValidUUID(s string) bool {
 	if len(s) != 36 {